@@ -0,0 +1,147 @@
+// The corpus check runs the samples of the detection corpus through the Preprocessor and the Detector. Every category
+// has a directory in the corpus with positive samples, which must be detected as the category, and negative samples,
+// which must not be detected as the category. Every line of a sample file is a sample; empty lines and comments
+// starting with "# " are ignored. A sample is sent as the value of the query argument "q", a sample of the form
+// "@header Name: value" as the value of the header "Name", a sample of the form "@url /path?query" as the request
+// URL as it is and a sample of the form "@json {...}" as JSON body.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpidetector"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpilogger"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+// checker runs the samples of the corpus
+type checker struct {
+	preprocessor *dpipreprocessor.Preprocessor
+	detector     *dpidetector.Detector
+	failures     int
+}
+
+func main() {
+	var ruleFiles, corpus string
+	flag.StringVar(&ruleFiles, "r", "./rules/path_traversal.yml,./rules/sql_injection.yml,./rules/xss.yml,./rules/command_injection.yml", "Comma separated list of rule files")
+	flag.StringVar(&corpus, "c", "./corpus", "Directory of the corpus")
+	flag.Parse()
+
+	dpiLogger, err := dpilogger.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	detector, err := dpidetector.New(dpiLogger, config.ServiceFunctionT{RuleFiles: strings.Split(ruleFiles, ",")})
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := &checker{preprocessor: dpipreprocessor.New(dpiLogger, config.BodyT{}), detector: detector}
+
+	categories, err := ioutil.ReadDir(corpus)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, category := range categories {
+		if category.IsDir() {
+			c.checkCategory(filepath.Join(corpus, category.Name()), category.Name())
+		}
+	}
+
+	if c.failures > 0 {
+		fmt.Printf("%d samples failed\n", c.failures)
+		os.Exit(1)
+	}
+}
+
+// checkCategory() runs the positive and negative samples of a category and reports the failed samples
+func (c *checker) checkCategory(dir, category string) {
+	for _, kind := range []string{"positive", "negative"} {
+		samples, err := readSamples(filepath.Join(dir, kind+".txt"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		passed := 0
+		for _, sample := range samples {
+			detected := c.detects(sample, category)
+			if detected == (kind == "positive") {
+				passed++
+				continue
+			}
+			c.failures++
+			if detected {
+				fmt.Printf("  false positive: %s\n", sample)
+			} else {
+				fmt.Printf("  not detected:   %s\n", sample)
+			}
+		}
+		fmt.Printf("%-20s %-8s %4d/%d passed\n", category, kind, passed, len(samples))
+	}
+}
+
+// detects() checks if a sample is detected as the category
+func (c *checker) detects(sample, category string) bool {
+	req := newRequest(sample)
+	data := c.preprocessor.ExtractConvertData(req)
+	defer data.Close()
+
+	for _, match := range c.detector.Detect(data) {
+		if match.Rule.Category == category {
+			return true
+		}
+	}
+	return false
+}
+
+// newRequest() creates the request, which carries a sample in the query argument "q" or in the location named by the
+// prefix of the sample
+func newRequest(sample string) *http.Request {
+	switch {
+	case strings.HasPrefix(sample, "@header "):
+		req := httptest.NewRequest("GET", "/", nil)
+		header := strings.TrimPrefix(sample, "@header ")
+		name, value := header, ""
+		if colon := strings.Index(header, ":"); colon >= 0 {
+			name, value = header[:colon], strings.TrimSpace(header[colon+1:])
+		}
+		req.Header.Set(name, value)
+		return req
+	case strings.HasPrefix(sample, "@url "):
+		return httptest.NewRequest("GET", strings.TrimPrefix(sample, "@url "), nil)
+	case strings.HasPrefix(sample, "@json "):
+		req := httptest.NewRequest("POST", "/", strings.NewReader(strings.TrimPrefix(sample, "@json ")))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	return httptest.NewRequest("GET", "/?q="+url.QueryEscape(sample), nil)
+}
+
+// readSamples() reads the samples of a sample file
+func readSamples(path string) (samples []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || line == "#" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		samples = append(samples, line)
+	}
+	return samples, scanner.Err()
+}
@@ -3,6 +3,7 @@ module github.com/vs-uulm/ztsfc_http_ips
 go 1.17
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/vs-uulm/ztsfc_http_logger v0.0.0-20211216171154-dd2ea2ce1e4d
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -21,11 +21,105 @@ type CertSetT struct {
 	Certs_sf_accepts             string `yaml:"certs_sf_accepts"`
 }
 
+// The struct EnforcementT defines how the DPI reacts to detections.
+// Mode is applied to all rule categories unless it is overridden for a
+// category in Categories (e.g. "sql_injection: detect").
+type EnforcementT struct {
+	Mode       string            `yaml:"mode"`
+	Categories map[string]string `yaml:"categories"`
+}
+
+// Enforcement modes supported by the DPI:
+// "detect" only alerts on malicious requests, "prevent" blocks them.
+const (
+	EnforcementModeDetect  = "detect"
+	EnforcementModePrevent = "prevent"
+)
+
+// The struct AnomalyScoringT defines the anomaly scoring of the DPI. If it is enabled, every matching rule adds the
+// score of its severity to the anomaly score of the request. A request is only blocked, if its anomaly score reaches
+// the inbound threshold.
+type AnomalyScoringT struct {
+	Enabled          bool           `yaml:"enabled"`
+	InboundThreshold int            `yaml:"inbound_threshold"`
+	SeverityScores   map[string]int `yaml:"severity_scores"`
+}
+
+// The struct InspectionT defines how much of a request body the DPI inspects. Only the first MaxSize bytes of a body
+// are inspected. ExceededAction decides about bodies, which exceed this limit: "inspect-partial-and-forward" inspects
+// the body up to the limit, "reject" blocks the request independent of the enforcement mode and "skip" forwards the
+// body without inspecting it. The body is read in chunks of ChunkSize bytes. Read bytes beyond MemoryLimit are spilled
+// to a temporary file in TempDir (the default directory for temporary files, when it is empty), from which they are
+// replayed to the service.
+type InspectionT struct {
+	MaxSize        int64  `yaml:"max_size"`
+	ExceededAction string `yaml:"exceeded_action"`
+	ChunkSize      int    `yaml:"chunk_size"`
+	MemoryLimit    int64  `yaml:"memory_limit"`
+	TempDir        string `yaml:"temp_dir"`
+}
+
+// The struct JSONT defines the limits of the JSON body parser. A JSON body, which exceeds one of them, is only
+// inspected up to the limit and reported as anomaly.
+type JSONT struct {
+	MaxDepth    int `yaml:"max_depth"`
+	MaxElements int `yaml:"max_elements"`
+}
+
+// The struct XMLT defines the limits of the XML body parser. A body, which exceeds them, is only inspected up to the
+// limit and reported as anomaly.
+type XMLT struct {
+	MaxDepth int `yaml:"max_depth"`
+	MaxSize  int `yaml:"max_size"`
+}
+
+// The struct DecompressionT defines the limits of the decompression of request bodies with a Content-Encoding.
+// A body, which decompresses to more than MaxSize bytes or more than MaxRatio times its compressed size, is only
+// inspected up to the limit and reported as anomaly. ExceededAction is the action of this anomaly ("block" or
+// "pass").
+type DecompressionT struct {
+	MaxSize        int64  `yaml:"max_size"`
+	MaxRatio       int    `yaml:"max_ratio"`
+	ExceededAction string `yaml:"exceeded_action"`
+}
+
+// The struct UploadPolicyT defines the checks of the file uploads of all requests, whose path starts with Path.
+// The policy with the longest matching path is applied. Limits, which are not set, use the defaults of the detector.
+// Without allowed extensions, all extensions except the denied extensions are allowed.
+type UploadPolicyT struct {
+	Path                string   `yaml:"path"`
+	MaxFileSize         int64    `yaml:"max_file_size"`
+	MaxTotalSize        int64    `yaml:"max_total_size"`
+	AllowedExtensions   []string `yaml:"allowed_extensions"`
+	DeniedExtensions    []string `yaml:"denied_extensions"`
+	AllowedContentTypes []string `yaml:"allowed_content_types"`
+}
+
+// The struct BodyT defines how the DPI parses request bodies. Limits, which are not set, use the defaults of the
+// preprocessor.
+type BodyT struct {
+	Inspection    InspectionT     `yaml:"inspection"`
+	JSON          JSONT           `yaml:"json"`
+	XML           XMLT            `yaml:"xml"`
+	Decompression DecompressionT  `yaml:"decompression"`
+	Uploads       []UploadPolicyT `yaml:"uploads"`
+}
+
 // The struct ServiceFunctionT is for parsing the section 'sf' of the config file.
 type ServiceFunctionT struct {
-	ListenAddr  string   `yaml:"listen_addr"`
-	ServerCerts CertSetT `yaml:"server"`
-	ClientCerts CertSetT `yaml:"client"`
+	ListenAddr     string          `yaml:"listen_addr"`
+	ServerCerts    CertSetT        `yaml:"server"`
+	ClientCerts    CertSetT        `yaml:"client"`
+	Enforcement    EnforcementT    `yaml:"enforcement"`
+	AnomalyScoring AnomalyScoringT `yaml:"anomaly_scoring"`
+	RuleFiles      []string        `yaml:"rule_files"`
+	// Files of the operating system, which must not be accessed by a request (e.g. "/etc/passwd")
+	SensitiveFiles []string `yaml:"sensitive_files"`
+	// Names of the parameters, which take file names and must not refer to remote or sensitive files (e.g. "page")
+	FileParameters []string `yaml:"file_parameters"`
+	// Networks in CIDR notation, which must not be targeted by URLs of a request (e.g. "10.20.0.0/16")
+	InternalNetworks []string `yaml:"internal_networks"`
+	Body             BodyT    `yaml:"body"`
 }
 
 // ConfigT struct is for parsing the basic structure of the config file
@@ -0,0 +1,53 @@
+package dpi
+
+import (
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpidetector"
+)
+
+/*
+This file implements the anomaly scoring of the DPI. Instead of blocking a request on the first match, every matching
+rule adds a score to the anomaly score of the request. The scores of all categories are accumulated and the request is
+blocked, when the total score reaches the configured inbound threshold.
+*/
+
+// newSeverityScores() converts the configured scores of the severities
+func newSeverityScores() map[dpidetector.Severity]int {
+	scores := make(map[dpidetector.Severity]int)
+	for name, score := range config.Config.SF.AnomalyScoring.SeverityScores {
+		severity, err := dpidetector.ParseSeverity(name)
+		if err == nil {
+			scores[severity] = score
+		}
+	}
+	return scores
+}
+
+/*
+This method accumulates the anomaly score of a request. Every rule contributes only once, even if it matched several
+fields of the request. Rules with the action "pass" contribute as well, they only do not block a request on their own.
+
+@param matches: All matches of the request
+
+@return score: Anomaly score of the request
+@return ruleIDs: IDs of the rules, which contributed to the score
+@return prevent: True, when at least one contributing rule belongs to a category enforced in prevent mode
+*/
+func (dpi *DPI) anomalyScore(matches []dpidetector.Match) (score int, ruleIDs []int, prevent bool) {
+	counted := make(map[*dpidetector.Rule]bool)
+	for _, match := range matches {
+		if counted[match.Rule] {
+			continue
+		}
+		counted[match.Rule] = true
+
+		ruleScore := match.Rule.Score
+		if ruleScore == 0 {
+			ruleScore = dpi.severityScores[match.Rule.Severity]
+		}
+		score += ruleScore
+		ruleIDs = append(ruleIDs, match.RuleID)
+		prevent = prevent || enforcementMode(match.Rule.Category) == config.EnforcementModePrevent
+	}
+	return score, ruleIDs, prevent
+}
@@ -0,0 +1,105 @@
+package dpi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpidetector"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpilogger"
+)
+
+// Rules of the matches of the anomaly scoring tests
+var (
+	criticalRule = &dpidetector.Rule{ID: 1, Category: "xss", Severity: dpidetector.SeverityCritical, Action: dpidetector.ActionBlock}
+	warningRule  = &dpidetector.Rule{ID: 2, Category: "xss", Severity: dpidetector.SeverityWarning, Action: dpidetector.ActionBlock}
+	errorRule    = &dpidetector.Rule{ID: 3, Category: "sql_injection", Severity: dpidetector.SeverityError, Action: dpidetector.ActionBlock}
+	scoredRule   = &dpidetector.Rule{ID: 4, Category: "xss", Severity: dpidetector.SeverityNotice, Action: dpidetector.ActionBlock, Score: 7}
+	passRule     = &dpidetector.Rule{ID: 5, Category: "xss", Severity: dpidetector.SeverityCritical, Action: dpidetector.ActionPass}
+)
+
+// newScoringDPI() creates a DPI with the default severity scores and the enforcement and anomaly scoring settings
+func newScoringDPI(t *testing.T, enforcement config.EnforcementT, scoring config.AnomalyScoringT) *DPI {
+	t.Helper()
+	dpiLogger, err := dpilogger.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoring.SeverityScores = map[string]int{"critical": 5, "error": 4, "warning": 3, "notice": 2}
+	config.Config.SF.Enforcement, config.Config.SF.AnomalyScoring = enforcement, scoring
+	return &DPI{dpiLogger: dpiLogger, severityScores: newSeverityScores()}
+}
+
+// matchesOf() returns a match of every rule
+func matchesOf(rules ...*dpidetector.Rule) (matches []dpidetector.Match) {
+	for _, rule := range rules {
+		matches = append(matches, dpidetector.Match{RuleID: rule.ID, Rule: rule})
+	}
+	return matches
+}
+
+func TestAnomalyScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		matches []dpidetector.Match
+		score   int
+		ruleIDs []int
+		prevent bool
+	}{
+		{"no matches", nil, 0, nil, false},
+		{"severity score", matchesOf(criticalRule), 5, []int{1}, true},
+		{"rule matching several fields", matchesOf(warningRule, warningRule), 3, []int{2}, true},
+		{"score of the rule", matchesOf(scoredRule), 7, []int{4}, true},
+		{"several rules", matchesOf(criticalRule, errorRule, warningRule), 12, []int{1, 3, 2}, true},
+		{"category in detect mode", matchesOf(errorRule), 4, []int{3}, false},
+		{"pass rule", matchesOf(passRule), 5, []int{5}, true},
+	}
+	dpi := newScoringDPI(t, config.EnforcementT{Mode: config.EnforcementModePrevent,
+		Categories: map[string]string{"sql_injection": config.EnforcementModeDetect}}, config.AnomalyScoringT{})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			score, ruleIDs, prevent := dpi.anomalyScore(test.matches)
+			if score != test.score || !reflect.DeepEqual(ruleIDs, test.ruleIDs) || prevent != test.prevent {
+				t.Errorf("score = %d, rules = %v, prevent = %v, want %d, %v, %v",
+					score, ruleIDs, prevent, test.score, test.ruleIDs, test.prevent)
+			}
+		})
+	}
+}
+
+func TestShouldBlock(t *testing.T) {
+	scoring := config.AnomalyScoringT{Enabled: true, InboundThreshold: 5}
+	tests := []struct {
+		name        string
+		enforcement config.EnforcementT
+		scoring     config.AnomalyScoringT
+		matches     []dpidetector.Match
+		block       bool
+	}{
+		{"first match", config.EnforcementT{Mode: config.EnforcementModePrevent}, config.AnomalyScoringT{},
+			matchesOf(warningRule), true},
+		{"first match in detect mode", config.EnforcementT{Mode: config.EnforcementModeDetect}, config.AnomalyScoringT{},
+			matchesOf(warningRule), false},
+		{"first match of a pass rule", config.EnforcementT{Mode: config.EnforcementModePrevent}, config.AnomalyScoringT{},
+			matchesOf(passRule), false},
+		{"score below the threshold", config.EnforcementT{Mode: config.EnforcementModePrevent}, scoring,
+			matchesOf(warningRule), false},
+		{"score reaching the threshold", config.EnforcementT{Mode: config.EnforcementModePrevent}, scoring,
+			matchesOf(warningRule, scoredRule), true},
+		{"score of a pass rule", config.EnforcementT{Mode: config.EnforcementModePrevent}, scoring,
+			matchesOf(passRule), true},
+		{"score of rules in detect mode", config.EnforcementT{Mode: config.EnforcementModeDetect}, scoring,
+			matchesOf(criticalRule, errorRule), false},
+		{"score of rules in detect and prevent mode", config.EnforcementT{Mode: config.EnforcementModeDetect,
+			Categories: map[string]string{"xss": config.EnforcementModePrevent}}, scoring,
+			matchesOf(warningRule, errorRule), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dpi := newScoringDPI(t, test.enforcement, test.scoring)
+			if block := dpi.shouldBlock(test.matches); block != test.block {
+				t.Errorf("block = %v, want %v", block, test.block)
+			}
+		})
+	}
+}
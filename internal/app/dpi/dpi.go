@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
 	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpidetector"
 	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpilogger"
 	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
@@ -18,6 +19,8 @@ type DPI struct {
 	dpiLogger    *dpilogger.DPILogger
 	detector     *dpidetector.Detector
 	preprocessor *dpipreprocessor.Preprocessor
+
+	severityScores map[dpidetector.Severity]int
 }
 
 func New() (DPI, error) {
@@ -25,12 +28,16 @@ func New() (DPI, error) {
 	if err != nil {
 		return DPI{}, err
 	}
-	detector := dpidetector.New(dpiLogger)
-	preprocessor := dpipreprocessor.New(dpiLogger)
+	detector, err := dpidetector.New(dpiLogger, config.Config.SF)
+	if err != nil {
+		return DPI{}, err
+	}
+	preprocessor := dpipreprocessor.New(dpiLogger, config.Config.SF.Body)
 	return DPI{name: "DPI",
-		dpiLogger:    dpiLogger,
-		detector:     &detector,
-		preprocessor: preprocessor}, nil
+		dpiLogger:      dpiLogger,
+		detector:       detector,
+		preprocessor:   preprocessor,
+		severityScores: newSeverityScores()}, nil
 }
 
 /*
@@ -44,28 +51,81 @@ In this method a request is investigated and decided if the request should be bl
 func (dpi *DPI) InvestigateRequest(w http.ResponseWriter, req *http.Request) bool {
 	// Extracting and preprocessing necessary data for the request
 	data := dpi.preprocessor.ExtractConvertData(req)
+	defer data.Close()
 
-	//	dpi.dpiLogger.Log("\n\n--- NEW REQUEST ---")
-	//	dpi.dpiLogger.Log(" URL: "+data[0])
+	// Investigate preprocessed data - Check if data matches to the loaded rules
+	matches := dpi.detector.Detect(data)
+	if len(matches) > 0 {
+		dpi.dpiLogger.Log(fmt.Sprintf("Request: %s %s, Canonical path: %s", req.Method, req.URL.RequestURI(), data.CanonicalPath))
+	}
+	for _, match := range matches {
+		dpi.logMatch(match)
+	}
 
-	//fmt.Println("DPI - Processed URL: " + data[0])
+	// A body, which exceeds the inspection limit with the policy "reject", was not inspected, so it is blocked
+	// independent of the matches, the enforcement mode and the anomaly score
+	if data.BodyRejected {
+		dpi.dpiLogger.Log("--!Request blocked! Body exceeds the inspection limit")
+		req.Body.Close()
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return false
+	}
 
-	// Investigate preprocessed data - Check if data matches to Path Traversal or SQL Injection
-	if dpi.detector.DetectPathTraversal(data) || dpi.detector.DetectSQLInjection(data) {
-		//		dpi.dpiLogger.Log("--!Request blocked!")
-		//fmt.Println("DPI: Request blocked")
-		//return false
-		return true // In the evaluation every request is forwarded (only alerts are provided by the DPI)
-	} else {
-		//		dpi.dpiLogger.Log(" Request forwarded")
-		//fmt.Println("Request forwarded")
+	if len(matches) == 0 {
 		return true
 	}
+
+	if dpi.shouldBlock(matches) {
+		// The body is not forwarded, so its temporary file is removed now
+		req.Body.Close()
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+
+	dpi.dpiLogger.Log(" Request forwarded despite matches")
+	return true
 }
 
-func (mw DPI) ApplyFunction(w http.ResponseWriter, req *http.Request) bool {
-	fmt.Printf("\n+++ ApplyFunction +++\nRequest: %v\n\n", req)
+// shouldBlock() decides based on the matches of a request, if the request is blocked
+func (dpi *DPI) shouldBlock(matches []dpidetector.Match) bool {
+	// With anomaly scoring the request is blocked, when its total score reaches the threshold
+	if config.Config.SF.AnomalyScoring.Enabled {
+		score, ruleIDs, prevent := dpi.anomalyScore(matches)
+		threshold := config.Config.SF.AnomalyScoring.InboundThreshold
+		dpi.dpiLogger.Log(fmt.Sprintf("Anomaly score: %d (threshold: %d), Rules: %v", score, threshold, ruleIDs))
+		if score >= threshold && prevent {
+			dpi.dpiLogger.Log("--!Request blocked! Anomaly score threshold reached")
+			return true
+		}
+		return false
+	}
+
+	// Otherwise the request is blocked as soon as one blocking rule of a category enforced in prevent mode matches
+	for _, match := range matches {
+		if match.Rule.Action == dpidetector.ActionBlock && enforcementMode(match.Rule.Category) == config.EnforcementModePrevent {
+			dpi.dpiLogger.Log("--!Request blocked! Category: " + match.Rule.Category)
+			return true
+		}
+	}
+	return false
+}
+
+// logMatch() writes a single detection with the metadata of the matching rule to the DPI log
+func (dpi *DPI) logMatch(match dpidetector.Match) {
+	dpi.dpiLogger.Log(fmt.Sprintf("!! Rule %d matched !! Category: %s, Severity: %s, Msg: %s, Field: %s, Offset: %d, Matched: %q",
+		match.RuleID, match.Rule.Category, match.Rule.Severity, match.Rule.Msg, match.Field, match.Offset, match.Matched))
+}
 
+// enforcementMode() returns the configured enforcement mode of a rule category.
+// A category specific mode overrides the global one.
+func enforcementMode(category string) string {
+	if mode, ok := config.Config.SF.Enforcement.Categories[category]; ok {
+		return mode
+	}
+	return config.Config.SF.Enforcement.Mode
+}
+
+func (mw DPI) ApplyFunction(w http.ResponseWriter, req *http.Request) bool {
 	// Investigate request with DPI
 	return mw.InvestigateRequest(w, req)
 }
@@ -0,0 +1,149 @@
+package dpi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+// Directory of the rule files bundled with the service function
+var bundledRules string
+
+func TestMain(m *testing.M) {
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	bundledRules = filepath.Join(wd, "..", "..", "..", "rules")
+
+	// The DPI log is written to a temporary directory instead of the package directory
+	dir, err := ioutil.TempDir("", "dpi")
+	if err != nil {
+		panic(err)
+	}
+	os.Chdir(dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+const testRules = `rules:
+  - id: 1000001
+    msg: 'Traversal'
+    category: path_traversal
+    severity: critical
+    type: literal
+    pattern: '../'
+    targets: [args]
+  - id: 1000002
+    msg: 'Script tag'
+    category: xss
+    severity: warning
+    type: literal
+    pattern: '<script'
+    targets: [args]
+  - id: 1000003
+    msg: 'Logged only'
+    category: xss
+    severity: critical
+    type: literal
+    pattern: 'alert('
+    targets: [args]
+    action: pass
+`
+
+// newTestDPI() creates a DPI with the test rules and the configuration of the service function
+func newTestDPI(t *testing.T, sf config.ServiceFunctionT) *DPI {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.yml")
+	if err := ioutil.WriteFile(path, []byte(testRules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sf.RuleFiles = append(sf.RuleFiles, path)
+	config.Config.SF = sf
+	dpi, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dpi
+}
+
+// investigate() sends a request through the DPI and returns if it was forwarded and the status of the response
+func investigate(dpi *DPI, method, target, body string) (bool, int) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	forward := dpi.InvestigateRequest(recorder, req)
+	return forward, recorder.Code
+}
+
+func TestInvestigateRequestEnforcement(t *testing.T) {
+	tests := []struct {
+		name        string
+		enforcement config.EnforcementT
+		target      string
+		forward     bool
+	}{
+		{"benign request", config.EnforcementT{Mode: config.EnforcementModePrevent}, "/?q=hello", true},
+		{"prevent mode", config.EnforcementT{Mode: config.EnforcementModePrevent}, "/?q=../etc", false},
+		{"detect mode", config.EnforcementT{Mode: config.EnforcementModeDetect}, "/?q=../etc", true},
+		{"category in detect mode", config.EnforcementT{Mode: config.EnforcementModePrevent,
+			Categories: map[string]string{"path_traversal": config.EnforcementModeDetect}}, "/?q=../etc", true},
+		{"category in prevent mode", config.EnforcementT{Mode: config.EnforcementModeDetect,
+			Categories: map[string]string{"path_traversal": config.EnforcementModePrevent}}, "/?q=../etc", false},
+		{"pass rule", config.EnforcementT{Mode: config.EnforcementModePrevent}, "/?q=alert(1)", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dpi := newTestDPI(t, config.ServiceFunctionT{Enforcement: test.enforcement})
+			forward, status := investigate(dpi, "GET", test.target, "")
+			if forward != test.forward {
+				t.Errorf("forward = %v, want %v", forward, test.forward)
+			}
+			if !forward && status != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", status, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestInvestigateRequestBodyRejected(t *testing.T) {
+	sf := config.ServiceFunctionT{Enforcement: config.EnforcementT{Mode: config.EnforcementModeDetect}}
+	sf.Body.Inspection = config.InspectionT{MaxSize: 16, ExceededAction: "reject"}
+	dpi := newTestDPI(t, sf)
+
+	// A rejected body is blocked even in detect mode and without a matching rule
+	forward, status := investigate(dpi, "POST", "/upload", strings.Repeat("a", 64))
+	if forward || status != http.StatusRequestEntityTooLarge {
+		t.Errorf("forward = %v, status = %d, want a rejected request", forward, status)
+	}
+	if forward, _ := investigate(dpi, "POST", "/upload", "small"); !forward {
+		t.Error("a body within the inspection limit was blocked")
+	}
+}
+
+// Invalid percent-encodings are sent by benign clients and only add to the anomaly score
+func TestInvestigateRequestInvalidEncoding(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(bundledRules, "*.yml"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no rule files found: %v", err)
+	}
+
+	for _, scoring := range []bool{false, true} {
+		sf := config.ServiceFunctionT{Enforcement: config.EnforcementT{Mode: config.EnforcementModePrevent}, RuleFiles: paths}
+		sf.AnomalyScoring = config.AnomalyScoringT{Enabled: scoring, InboundThreshold: 5,
+			SeverityScores: map[string]int{"critical": 5, "error": 4, "warning": 3, "notice": 2}}
+		dpi := newTestDPI(t, sf)
+		if forward, status := investigate(dpi, "GET", "/search?q=100%", ""); !forward {
+			t.Errorf("anomaly scoring %v: status = %d, want the request forwarded", scoring, status)
+		}
+		if forward, _ := investigate(dpi, "GET", "/search?q=100%&f=../../etc/passwd", ""); forward {
+			t.Errorf("anomaly scoring %v: traversal forwarded", scoring)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package dpidetector
+
+/*
+This file implements the Aho-Corasick multi-pattern matching automaton. All literal patterns of a rule group are
+compiled into one automaton at load time, so an input is searched for all of them in a single pass, independent of the
+number of patterns.
+*/
+
+type ahoCorasick struct {
+	// Bytes, which do not occur in any pattern, share the class 0. This keeps the transition table small.
+	classes    [256]int32
+	numClasses int32
+
+	// Transition table of the deterministic automaton: delta[state*numClasses+class]
+	delta []int32
+	// Patterns ending in a state, including the patterns of its suffix states
+	outputs [][]int32
+	lengths []int
+}
+
+/*
+This function builds the automaton for the given patterns.
+
+@param patterns: Non-empty literal patterns; the index of a pattern is used to report its matches
+
+@return automaton: Compiled automaton
+*/
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	ac := &ahoCorasick{lengths: make([]int, len(patterns)), numClasses: 1}
+	for _, pattern := range patterns {
+		for i := 0; i < len(pattern); i++ {
+			if ac.classes[pattern[i]] == 0 {
+				ac.classes[pattern[i]] = ac.numClasses
+				ac.numClasses++
+			}
+		}
+	}
+
+	// Build the trie of all patterns. A transition of -1 does not exist yet.
+	newState := func() int32 {
+		for class := int32(0); class < ac.numClasses; class++ {
+			ac.delta = append(ac.delta, -1)
+		}
+		ac.outputs = append(ac.outputs, nil)
+		return int32(len(ac.outputs) - 1)
+	}
+	newState()
+	for index, pattern := range patterns {
+		ac.lengths[index] = len(pattern)
+		state := int32(0)
+		for i := 0; i < len(pattern); i++ {
+			next := &ac.delta[state*ac.numClasses+ac.classes[pattern[i]]]
+			if *next < 0 {
+				created := newState()
+				// The append of newState() may have moved the table
+				next = &ac.delta[state*ac.numClasses+ac.classes[pattern[i]]]
+				*next = created
+			}
+			state = *next
+		}
+		ac.outputs[state] = append(ac.outputs[state], int32(index))
+	}
+
+	// Compute the failure links in breadth-first order and turn the trie into a complete transition table
+	fail := make([]int32, len(ac.outputs))
+	var queue []int32
+	for class := int32(0); class < ac.numClasses; class++ {
+		if next := ac.delta[class]; next < 0 {
+			ac.delta[class] = 0
+		} else {
+			queue = append(queue, next)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		ac.outputs[state] = append(ac.outputs[state], ac.outputs[fail[state]]...)
+		for class := int32(0); class < ac.numClasses; class++ {
+			next := ac.delta[state*ac.numClasses+class]
+			fallback := ac.delta[fail[state]*ac.numClasses+class]
+			if next < 0 {
+				ac.delta[state*ac.numClasses+class] = fallback
+			} else {
+				fail[next] = fallback
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return ac
+}
+
+/*
+This method searches the input for all patterns of the automaton in a single pass.
+
+@param input: Input, which should be searched
+@param scratch: Buffer for the offsets, which is reused for all inputs of a request; a new buffer is allocated, when
+it is too small
+
+@return offsets: Byte offset of the first occurrence of every pattern in the input; -1, when a pattern does not occur
+*/
+func (ac *ahoCorasick) firstOffsets(input string, scratch []int) []int {
+	offsets := scratch
+	if cap(offsets) < len(ac.lengths) {
+		offsets = make([]int, len(ac.lengths))
+	}
+	offsets = offsets[:len(ac.lengths)]
+	for i := range offsets {
+		offsets[i] = -1
+	}
+	if len(ac.lengths) == 0 {
+		return offsets
+	}
+
+	state := int32(0)
+	for i := 0; i < len(input); i++ {
+		state = ac.delta[state*ac.numClasses+ac.classes[input[i]]]
+		for _, pattern := range ac.outputs[state] {
+			if offsets[pattern] < 0 {
+				offsets[pattern] = i + 1 - ac.lengths[pattern]
+			}
+		}
+	}
+	return offsets
+}
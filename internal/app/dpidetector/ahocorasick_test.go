@@ -0,0 +1,71 @@
+package dpidetector
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// naiveOffsets() searches the first occurrence of every pattern separately
+func naiveOffsets(patterns []string, input string) []int {
+	offsets := make([]int, len(patterns))
+	for i, pattern := range patterns {
+		offsets[i] = strings.Index(input, pattern)
+	}
+	return offsets
+}
+
+func TestAhoCorasick(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		input    string
+		want     []int
+	}{
+		{"single pattern", []string{"../"}, "/a/../b/../c", []int{3}},
+		{"overlapping patterns", []string{"he", "she", "his", "hers"}, "ushers", []int{2, 1, -1, 2}},
+		{"suffix of another pattern", []string{"select", "elect", "t"}, "xselect", []int{1, 2, 6}},
+		{"duplicate patterns", []string{"ab", "ab"}, "xxab", []int{2, 2}},
+		{"pattern at the end", []string{"union"}, "1 union", []int{2}},
+		{"no match", []string{"<script", "javascript:"}, "<scrip javascript", []int{-1, -1}},
+		{"bytes above 0x7f", []string{"\xc0\xaf", "ä"}, "a\xc0\xafä", []int{1, 3}},
+		{"failure to shorter pattern", []string{"abcd", "bce"}, "abce", []int{-1, 1}},
+		{"empty input", []string{"a"}, "", []int{-1}},
+		{"no patterns", nil, "abc", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			offsets := newAhoCorasick(test.patterns).firstOffsets(test.input, nil)
+			if !reflect.DeepEqual(offsets, test.want) {
+				t.Errorf("offsets = %v, want %v", offsets, test.want)
+			}
+		})
+	}
+}
+
+// The automaton finds the same first occurrences as a separate search of every pattern
+func TestAhoCorasickRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomString := func(maxLength int) string {
+		bytes := make([]byte, 1+random.Intn(maxLength))
+		for i := range bytes {
+			bytes[i] = "abc\x00\xff"[random.Intn(5)]
+		}
+		return string(bytes)
+	}
+
+	scratch := make([]int, 4)
+	for i := 0; i < 1000; i++ {
+		patterns := make([]string, 1+random.Intn(8))
+		for j := range patterns {
+			patterns[j] = randomString(4)
+		}
+		input := randomString(32)
+		// The scratch buffer is reused and grown like in Detect()
+		scratch = newAhoCorasick(patterns).firstOffsets(input, scratch)
+		if want := naiveOffsets(patterns, input); !reflect.DeepEqual(scratch, want) {
+			t.Fatalf("patterns %q in %q: offsets = %v, want %v", patterns, input, scratch, want)
+		}
+	}
+}
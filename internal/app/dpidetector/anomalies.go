@@ -0,0 +1,145 @@
+package dpidetector
+
+import (
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file converts the protocol anomalies, which the Preprocessor found in a request, into matches. Every type of
+anomaly is described by a built-in rule, so anomalies are logged, scored and enforced like the matches of other rules.
+Invalid encodings and malformed JSON and XML bodies are often sent by benign clients (e.g. "?q=100%"), so they only
+add to the anomaly score. The actions of the decompression and inspection limit anomalies can be configured.
+*/
+
+const CategoryProtocolAnomaly = "protocol_anomaly"
+
+// Built-in rules for the types of protocol anomalies
+var anomalyRules = map[string]*Rule{
+	dpipreprocessor.AnomalyInvalidEncoding: {
+		ID:       9001,
+		Msg:      "Protocol anomaly: invalid percent-encoding",
+		Category: CategoryProtocolAnomaly,
+		Severity: SeverityWarning,
+		References: []string{
+			"https://datatracker.ietf.org/doc/html/rfc3986#section-2.1",
+		},
+		Action: ActionPass,
+	},
+	dpipreprocessor.AnomalyMalformedJSON: {
+		ID:       9002,
+		Msg:      "Protocol anomaly: malformed JSON body",
+		Category: CategoryProtocolAnomaly,
+		Severity: SeverityWarning,
+		References: []string{
+			"https://datatracker.ietf.org/doc/html/rfc8259",
+		},
+		Action: ActionPass,
+	},
+	dpipreprocessor.AnomalyJSONLimit: {
+		ID:       9003,
+		Msg:      "Protocol anomaly: JSON body exceeds the depth or element limit",
+		Category: CategoryProtocolAnomaly,
+		Severity: SeverityWarning,
+		Action:   ActionBlock,
+	},
+	dpipreprocessor.AnomalyMalformedMultipart: {
+		ID:       9004,
+		Msg:      "Protocol anomaly: malformed multipart/form-data body",
+		Category: CategoryProtocolAnomaly,
+		Severity: SeverityWarning,
+		References: []string{
+			"https://datatracker.ietf.org/doc/html/rfc7578",
+		},
+		Action: ActionBlock,
+	},
+	dpipreprocessor.AnomalyMalformedXML: {
+		ID:       9005,
+		Msg:      "Protocol anomaly: malformed XML body",
+		Category: CategoryProtocolAnomaly,
+		Severity: SeverityWarning,
+		References: []string{
+			"https://www.w3.org/TR/xml/",
+		},
+		Action: ActionPass,
+	},
+	dpipreprocessor.AnomalyXMLLimit: {
+		ID:       9006,
+		Msg:      "Protocol anomaly: XML body exceeds the depth or size limit",
+		Category: CategoryProtocolAnomaly,
+		Severity: SeverityWarning,
+		Action:   ActionBlock,
+	},
+	dpipreprocessor.AnomalyDecompressionLimit: {
+		ID:       9007,
+		Msg:      "Protocol anomaly: compressed body exceeds the decompression limits",
+		Category: CategoryProtocolAnomaly,
+		Severity: SeverityError,
+		References: []string{
+			"https://cwe.mitre.org/data/definitions/409.html",
+		},
+		Action: ActionBlock,
+	},
+	dpipreprocessor.AnomalyInvalidContentEncoding: {
+		ID:       9008,
+		Msg:      "Protocol anomaly: invalid or unsupported content encoding",
+		Category: CategoryProtocolAnomaly,
+		Severity: SeverityWarning,
+		References: []string{
+			"https://datatracker.ietf.org/doc/html/rfc9110#section-8.4",
+		},
+		Action: ActionBlock,
+	},
+	dpipreprocessor.AnomalyBodyLimit: {
+		ID:       9009,
+		Msg:      "Protocol anomaly: body exceeds the inspection limit",
+		Category: CategoryProtocolAnomaly,
+		Severity: SeverityNotice,
+		Action:   ActionPass,
+	},
+}
+
+// newAnomalyRules() copies the built-in anomaly rules for a Detector and applies the configured actions
+func newAnomalyRules(sf config.ServiceFunctionT) map[string]*Rule {
+	rules := make(map[string]*Rule, len(anomalyRules))
+	for anomalyType, rule := range anomalyRules {
+		copied := *rule
+		rules[anomalyType] = &copied
+	}
+	if action := sf.Body.Decompression.ExceededAction; action != "" {
+		rules[dpipreprocessor.AnomalyDecompressionLimit].Action = action
+	}
+	// A rejected body is blocked by the DPI independent of the enforcement mode (see Request.BodyRejected); its anomaly
+	// is logged as critical
+	if sf.Body.Inspection.ExceededAction == dpipreprocessor.InspectionReject {
+		rules[dpipreprocessor.AnomalyBodyLimit].Action = ActionBlock
+		rules[dpipreprocessor.AnomalyBodyLimit].Severity = SeverityCritical
+	}
+	return rules
+}
+
+// builtinRules() returns all rules, which are implemented by the Detector itself
+func builtinRules() (rules []*Rule) {
+	for _, rule := range anomalyRules {
+		rules = append(rules, rule)
+	}
+	rules = append(rules, ruleTraversalEscape, ruleSensitiveFile)
+	rules = append(rules, inclusionRules()...)
+	rules = append(rules, nosqlRules()...)
+	rules = append(rules, uploadRules()...)
+	rules = append(rules, xxeRules()...)
+	rules = append(rules, expressionRules()...)
+	return append(rules, ssrfRules()...)
+}
+
+// detectAnomalies() reports every protocol anomaly of the request as match of its built-in rule
+func (detector *Detector) detectAnomalies(request *dpipreprocessor.Request) (matches []Match) {
+	for _, anomaly := range request.Anomalies {
+		rule, ok := detector.anomalyRules[anomaly.Type]
+		if !ok {
+			continue
+		}
+		matches = append(matches, newMatch(rule, fieldDescription(anomaly.Location, anomaly.Name), anomaly.Value, 0, len(anomaly.Value)))
+	}
+	return matches
+}
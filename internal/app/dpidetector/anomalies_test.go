@@ -0,0 +1,55 @@
+package dpidetector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+// gzipped() compresses the content with gzip
+func gzipped(content []byte) string {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write(content)
+	writer.Close()
+	return buffer.String()
+}
+
+// Rules match the decompressed content of a body and a decompression bomb is reported with the configured action
+func TestDetectCompressedBody(t *testing.T) {
+	rules := `rules:
+  - {id: 1000001, category: test, severity: notice, type: literal, pattern: 'union select', targets: [body]}
+`
+	headers := map[string]string{"Content-Encoding": "gzip", "Content-Type": "text/plain"}
+	payload := testRequest{method: "POST", target: "/", headers: headers, body: gzipped([]byte("1 union select 2"))}
+	bomb := testRequest{method: "POST", target: "/", headers: headers, body: gzipped(make([]byte, 4<<20))}
+
+	tests := []struct {
+		name    string
+		action  string
+		request testRequest
+		want    []int
+		blocks  bool
+	}{
+		{"compressed payload", "", payload, []int{1000001}, true},
+		{"bomb", "", bomb, []int{9007}, true},
+		{"bomb with action pass", ActionPass, bomb, []int{9007}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sf := config.ServiceFunctionT{Body: config.BodyT{Decompression: config.DecompressionT{ExceededAction: test.action}}}
+			detector := newTestDetector(t, "test.yml", rules, sf)
+			if ids := detect(t, detector, test.request); !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+			rule := detector.anomalyRules[dpipreprocessor.AnomalyDecompressionLimit]
+			if blocks := rule.Action == ActionBlock; blocks != test.blocks {
+				t.Errorf("action = %s, want blocking %v", rule.Action, test.blocks)
+			}
+		})
+	}
+}
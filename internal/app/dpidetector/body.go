@@ -0,0 +1,62 @@
+package dpidetector
+
+import (
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file matches the rules against bodies, which the Preprocessor spilled to a temporary file because of their size.
+Such a body is not a field of the request, but is read chunk by chunk. Consecutive chunks overlap, so patterns
+spanning the boundary of two chunks are found, too.
+*/
+
+// Minimum number of bytes, by which the chunks of a body overlap. Regular expressions can match inputs of any length,
+// so their matches across a chunk boundary are only found up to this length.
+const minChunkOverlap = 4 << 10
+
+// chunkOverlap() returns the overlap of the chunks, which contains the longest literal of all rule groups
+func chunkOverlap(groups []*ruleGroup) int {
+	overlap := minChunkOverlap
+	for _, group := range groups {
+		for _, length := range group.automaton.lengths {
+			// A literal may be percent-encoded in the body, which triples its length
+			if 3*length > overlap {
+				overlap = 3 * length
+			}
+		}
+	}
+	return overlap
+}
+
+/*
+This method matches all rules against the chunks of a spilled body. Every chunk is inspected like a body field, so
+the transforms and targets of the rules apply as usual. A rule is only reported for its first matching chunk.
+
+@param request: Preprocessed request
+
+@return matches: Matches of the rules; the offset of a match is relative to the beginning of the body
+*/
+func (detector *Detector) detectBodyChunks(request *dpipreprocessor.Request) (matches []Match) {
+	matched := make(map[*Rule]bool)
+	scratch := make([]int, detector.maxPatterns)
+	err := request.ScanBody(detector.chunkOverlap, func(offset int64, chunk string) {
+		part := &dpipreprocessor.Request{Fields: []dpipreprocessor.Field{
+			{Location: dpipreprocessor.LocationBody, Value: chunk, RawValue: chunk},
+		}}
+		in := &requestInputs(part)[0]
+		for _, group := range detector.groups {
+			for _, match := range group.match(in, part.Transformed(in.index, false, group.view, group.transforms), scratch) {
+				if matched[match.Rule] {
+					continue
+				}
+				matched[match.Rule] = true
+				match.Offset += int(offset)
+				matches = append(matches, match)
+			}
+		}
+	})
+	if err != nil {
+		detector.dpiLogger.Log("Body could not be inspected: " + err.Error())
+	}
+	return matches
+}
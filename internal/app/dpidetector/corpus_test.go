@@ -0,0 +1,98 @@
+package dpidetector
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+// corpusRequest() creates the request, which carries a sample of the detection corpus in the query argument "q" or in
+// the location named by the prefix of the sample (see cmd/ztsfc_http_ips_corpus)
+func corpusRequest(sample string) testRequest {
+	switch {
+	case strings.HasPrefix(sample, "@header "):
+		header := strings.TrimPrefix(sample, "@header ")
+		name, value := header, ""
+		if colon := strings.Index(header, ":"); colon >= 0 {
+			name, value = header[:colon], strings.TrimSpace(header[colon+1:])
+		}
+		return testRequest{target: "/", headers: map[string]string{name: value}}
+	case strings.HasPrefix(sample, "@url "):
+		return testRequest{target: strings.TrimPrefix(sample, "@url ")}
+	case strings.HasPrefix(sample, "@json "):
+		return testRequest{method: "POST", target: "/", headers: map[string]string{"Content-Type": "application/json"},
+			body: strings.TrimPrefix(sample, "@json ")}
+	}
+	return testRequest{target: "/?q=" + url.QueryEscape(sample)}
+}
+
+// readCorpusSamples() reads the samples of a sample file; empty lines and comments are skipped
+func readCorpusSamples(t *testing.T, path string) (samples []string) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || line == "#" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		samples = append(samples, line)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+// TestCorpus runs the detection corpus: the positive samples of every category must be detected as the category, the
+// negative samples must not
+func TestCorpus(t *testing.T) {
+	paths, err := filepath.Glob("../../../rules/*.yml")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no rule files found: %v", err)
+	}
+	detector, err := New(testLogger, config.ServiceFunctionT{RuleFiles: paths})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	categories, err := ioutil.ReadDir("../../../corpus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, category := range categories {
+		if !category.IsDir() {
+			continue
+		}
+		for _, kind := range []string{"positive", "negative"} {
+			t.Run(category.Name()+"/"+kind, func(t *testing.T) {
+				samples := readCorpusSamples(t, filepath.Join("../../../corpus", category.Name(), kind+".txt"))
+				for _, sample := range samples {
+					data := corpusRequest(sample).preprocess()
+					detected := false
+					for _, match := range detector.Detect(data) {
+						detected = detected || match.Rule.Category == category.Name()
+					}
+					data.Close()
+
+					if detected && kind == "negative" {
+						t.Errorf("false positive: %s", sample)
+					} else if !detected && kind == "positive" {
+						t.Errorf("not detected: %s", sample)
+					}
+				}
+			})
+		}
+	}
+}
@@ -2,10 +2,11 @@ package dpidetector
 
 import (
 	"fmt"
-	"regexp"
-	"strings"
+	"net"
 
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
 	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpilogger"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
 )
 
 /*
@@ -15,64 +16,89 @@ Preprocessor are suspicious. Therefore, all inputs are checked according to the
 
 type Detector struct {
 	dpiLogger *dpilogger.DPILogger
+	rules     []*Rule
+	groups    []*ruleGroup
+
+	maxPatterns  int // Size of the scratch buffer for the offsets of the automatons (see matcher.go)
+	chunkOverlap int // Overlap of the chunks of spilled bodies (see body.go)
+
+	sensitiveFiles   []string         // Canonical paths of the sensitive files (see traversal.go)
+	fileParameters   map[string]bool  // Names of the parameters, which take file names (see inclusion.go)
+	uploadPolicies   []*uploadPolicy  // Upload policies of the routes (see uploads.go)
+	anomalyRules     map[string]*Rule // Built-in rules of the protocol anomalies (see anomalies.go)
+	internalNetworks []*net.IPNet     // Networks, which must not be targeted by URLs of a request (see ssrf.go)
 }
 
 /*
-For all provided inputs is checked, if at least one input matches to the patterns of path traversal.
+For all fields of the preprocessed request is checked, if they match to the rules loaded from the rule files.
 
-@param inputs: Inputs of the preprocessor, which should be analyzed according to the signatures
+@param request: Request of the preprocessor, which should be analyzed according to the signatures
 
-@return detection: True, when a malicious input was detected; False, when no malicious input was detected
+@return matches: All matches of the rules; empty, when no malicious input was detected
 */
-func (detector *Detector) DetectPathTraversal(inputs []string) (detection bool) {
-	detection = false
-	for _, input := range inputs { // Iterate over all inputs provided from the preprocessor
-		for _, pattern := range patternPathTrav { // Iterate over all patterns for Path Traversal
-			// Check, if a pattern for path traversal matches to a user-input
-			match := strings.Contains(input, pattern)
-			if match {
-				detector.dpiLogger.Log("!! Path traversal match !!")
-				fmt.Println("!! Path traversal match !!")
-				detector.dpiLogger.Log("--Pattern: " + pattern)
-				fmt.Println("--Pattern: " + pattern)
-				detector.dpiLogger.Log("--Input: " + input)
-				fmt.Println("--Input: " + input)
-
-				detection = true
-			}
+func (detector *Detector) Detect(request *dpipreprocessor.Request) (matches []Match) {
+	inputs := requestInputs(request)
+	scratch := make([]int, detector.maxPatterns)
+	for i := range inputs { // Iterate over all inputs provided from the preprocessor
+		in := &inputs[i]
+		if in.value == "" {
+			continue
+		}
+		for _, group := range detector.groups { // Iterate over all rule groups, which share the same view and transforms
+			matches = append(matches, group.match(in, request.Transformed(in.index, in.isName, group.view, group.transforms), scratch)...)
 		}
 	}
-	return detection
+	matches = append(matches, detector.detectBodyChunks(request)...)
+	matches = append(resolveChains(matches), detector.detectTraversal(request, inputs)...)
+	matches = append(matches, detector.detectInclusion(request, inputs)...)
+	matches = append(matches, detectNoSQL(request, inputs)...)
+	matches = append(matches, detector.detectUploads(request)...)
+	matches = append(matches, detectXXE(request)...)
+	matches = append(matches, detectExpressions(request, inputs)...)
+	matches = append(matches, detector.detectSSRF(request, inputs)...)
+	return append(matches, detector.detectAnomalies(request)...)
 }
 
-/*
-For all provided inputs is checked, if at least one input matches to the regular expressions of SQL injection.
-
-@param inputs: Inputs of the preprocessor, which should be analyzed according to the signatures
-
-@return detection: True, when a malicious input was detected; False, when no malicious input was detected
-*/
-func (detector *Detector) DetectSQLInjection(inputs []string) (detection bool) {
-	detection = false
-	for _, input := range inputs { // Iterate over all inputs provided from the preprocessor
-		for _, rule := range regexSQLInject { // Iterate over all regular expressions for SQL Injection
-			// Check, if a regular expression for SQL-Injection matches with a user-input
-			matched, _ := regexp.MatchString(rule, input)
-			if matched {
-				detector.dpiLogger.Log("!! SQL injection match !!")
-				fmt.Println("!! SQL injection match !!")
-				detector.dpiLogger.Log("--Pattern: " + rule)
-				fmt.Println("--Pattern: " + rule)
-				detector.dpiLogger.Log("--Input: " + input)
-				fmt.Println("--Input: " + input)
+func newMatch(rule *Rule, field, input string, start, end int) Match {
+	return Match{
+		RuleID:  rule.ID,
+		Rule:    rule,
+		Field:   field,
+		Matched: input[start:end],
+		Offset:  start,
+	}
+}
 
-				detection = true
+// New() creates a new Detector with the rules of the rule files and the settings of the service function
+// configuration. Without sensitive files the DefaultSensitiveFiles are used, without file parameters the
+// DefaultFileParameters.
+func New(_logDPI *dpilogger.DPILogger, sf config.ServiceFunctionT) (*Detector, error) {
+	rules, skipped, err := LoadRuleFiles(sf.RuleFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range skipped {
+		_logDPI.Log("Skipped unsupported rule: " + rule.String())
+	}
+	for _, builtin := range builtinRules() {
+		for _, rule := range rules {
+			if rule.ID == builtin.ID {
+				return nil, fmt.Errorf("dpidetector: New(): rule %d: id is reserved for the built-in rule '%s'", rule.ID, builtin.Msg)
 			}
 		}
 	}
-	return detection
-}
-
-func New(_logDPI *dpilogger.DPILogger) Detector {
-	return Detector{dpiLogger: _logDPI}
+	internalNetworks, err := parseInternalNetworks(sf.InternalNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("dpidetector: New(): %w", err)
+	}
+	_logDPI.Log(fmt.Sprintf("Loaded %d rules from %d rule files", len(rules), len(sf.RuleFiles)))
+	groups := newRuleGroups(rules)
+	return &Detector{dpiLogger: _logDPI, rules: rules, groups: groups,
+		maxPatterns:      maxPatterns(groups),
+		chunkOverlap:     chunkOverlap(groups),
+		sensitiveFiles:   sensitiveFilePaths(sf.SensitiveFiles),
+		fileParameters:   fileParameterSet(sf.FileParameters),
+		uploadPolicies:   newUploadPolicies(sf.Body.Uploads),
+		anomalyRules:     newAnomalyRules(sf),
+		internalNetworks: internalNetworks}, nil
 }
@@ -0,0 +1,290 @@
+package dpidetector
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpilogger"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+var testLogger *dpilogger.DPILogger
+
+func TestMain(m *testing.M) {
+	// The DPI log is written to a temporary directory instead of the package directory
+	dir, err := ioutil.TempDir("", "dpidetector")
+	if err != nil {
+		panic(err)
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	testLogger, err = dpilogger.New()
+	os.Chdir(wd)
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// writeRuleFile() writes a rule file with the given name and content into a temporary directory
+func writeRuleFile(t testing.TB, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestDetector() creates a Detector with the rules of a single rule file
+func newTestDetector(t testing.TB, name, rules string, sf config.ServiceFunctionT) *Detector {
+	t.Helper()
+	if rules != "" {
+		sf.RuleFiles = append(sf.RuleFiles, writeRuleFile(t, name, rules))
+	}
+	detector, err := New(testLogger, sf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return detector
+}
+
+// testRequest is a request, which is preprocessed and checked by a Detector
+type testRequest struct {
+	method  string
+	target  string
+	headers map[string]string
+	body    string
+}
+
+// preprocess() converts the request into a preprocessed request
+func (request testRequest) preprocess() *dpipreprocessor.Request {
+	if request.method == "" {
+		request.method = "GET"
+	}
+	httpRequest := httptest.NewRequest(request.method, request.target, strings.NewReader(request.body))
+	for name, value := range request.headers {
+		httpRequest.Header.Set(name, value)
+	}
+	return dpipreprocessor.New(nil, config.BodyT{}).ExtractConvertData(httpRequest)
+}
+
+// detect() preprocesses the request and returns the sorted IDs of the matching rules
+func detect(t testing.TB, detector *Detector, request testRequest) []int {
+	t.Helper()
+	data := request.preprocess()
+	defer data.Close()
+
+	var ids []int
+	for _, match := range detector.Detect(data) {
+		ids = append(ids, match.RuleID)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// categoryIDs() returns the IDs of the built-in rules of the category, which are contained in the IDs, without
+// duplicates
+func categoryIDs(ids []int, category string) (filtered []int) {
+	for _, rule := range builtinRules() {
+		if rule.Category == category && containsID(ids, rule.ID) {
+			filtered = append(filtered, rule.ID)
+		}
+	}
+	sort.Ints(filtered)
+	return filtered
+}
+
+// containsID() checks if the rule ID is one of the IDs
+func containsID(ids []int, id int) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDetectMatch(t *testing.T) {
+	detector := newTestDetector(t, "test.yml", `rules:
+  - id: 1000001
+    msg: 'SQL union select'
+    category: sql_injection
+    severity: error
+    references: ['https://owasp.org/www-community/attacks/SQL_Injection']
+    type: regex
+    pattern: 'union\s+select'
+    targets: [args]
+`, config.ServiceFunctionT{})
+	data := testRequest{target: "/?id=1&q=1+union+select+name"}.preprocess()
+	defer data.Close()
+
+	matches := detector.Detect(data)
+	if len(matches) != 1 {
+		t.Fatalf("matches = %+v, want one match", matches)
+	}
+	match := matches[0]
+	if match.RuleID != 1000001 || match.Field != "query:q" || match.Matched != "union select" || match.Offset != 2 {
+		t.Errorf("match = %+v, want the match of 'union select' at offset 2 of query:q", match)
+	}
+	rule := match.Rule
+	if rule.Msg != "SQL union select" || rule.Category != "sql_injection" || rule.Severity != SeverityError ||
+		len(rule.References) != 1 || rule.Action != ActionBlock {
+		t.Errorf("rule = %+v, want the metadata of the rule file", rule)
+	}
+}
+
+// Rules choose the view and the case handling of the fields they inspect
+func TestRuleViews(t *testing.T) {
+	detector := newTestDetector(t, "test.yml", `rules:
+  - {id: 1000001, category: test, severity: notice, type: literal, pattern: '%27', targets: [args], view: raw}
+  - {id: 1000002, category: test, severity: notice, type: literal, pattern: "'", targets: [args]}
+  - {id: 1000003, category: test, severity: notice, type: literal, pattern: 'Select', targets: [args]}
+  - {id: 1000004, category: test, severity: notice, type: literal, pattern: 'select', targets: [args],
+     transforms: [lowercase]}
+  - {id: 1000005, category: test, severity: notice, type: literal, pattern: 'q%5b', targets: [args_names], view: raw,
+     transforms: [lowercase]}
+`, config.ServiceFunctionT{})
+
+	tests := []struct {
+		target string
+		want   []int
+	}{
+		{"/?q=%27", []int{1000001, 1000002}},
+		{"/?q='", []int{1000002}},
+		{"/?q=Select", []int{1000003, 1000004}},
+		{"/?q=SELECT", []int{1000004}},
+		{"/?Q%5B%5D=1", []int{1000005}},
+		{"/?q[]=1", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			if ids := detect(t, detector, testRequest{target: test.target}); !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+// Typical benign and malicious requests for the benchmark
+var benchmarkRequests = []testRequest{
+	{target: "/index.html", headers: map[string]string{
+		"User-Agent": "Mozilla/5.0 (X11; Linux x86_64; rv:91.0) Gecko/20100101 Firefox/91.0",
+		"Accept":     "text/html,application/xhtml+xml",
+		"Cookie":     "session=9f86d081884c7d659a2feaa0c55ad015"}},
+	{target: "/api/v1/users?id=42&sort=name", headers: map[string]string{
+		"User-Agent": "curl/7.79.1", "Accept": "application/json"}},
+	{target: "/search?q=where+to+select+the+best+hotel+from+the+list", headers: map[string]string{
+		"User-Agent": "Mozilla/5.0", "Accept-Language": "de-DE,de;q=0.9,en;q=0.8"}},
+	{method: "POST", target: "/login", body: "username=alice&password=correct+horse+battery+staple",
+		headers: map[string]string{"User-Agent": "Mozilla/5.0", "Content-Type": "application/x-www-form-urlencoded"}},
+	{target: "/products?id=1%20union%20select%20username,%20password%20from%20users--", headers: map[string]string{
+		"User-Agent": "sqlmap/1.5"}},
+	{target: "/download?file=../../../../etc/passwd", headers: map[string]string{"User-Agent": "Mozilla/5.0"}},
+}
+
+// BenchmarkDetect measures the per-request cost of the Detector with the rules in ./rules. The requests are
+// preprocessed once, only the detection is measured.
+func BenchmarkDetect(b *testing.B) {
+	paths, err := filepath.Glob("../../../rules/*")
+	if err != nil {
+		b.Fatal(err)
+	}
+	detector, err := New(testLogger, config.ServiceFunctionT{RuleFiles: paths})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var requests []*dpipreprocessor.Request
+	for _, request := range benchmarkRequests {
+		data := request.preprocess()
+		defer data.Close()
+		requests = append(requests, data)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		detector.Detect(requests[i%len(requests)])
+	}
+}
+
+// BenchmarkRuleGroups compares the matching of the rules in ./rules with the automaton and the regex prefilter to
+// the matching of every rule on its own, as it was done before the rule groups
+func BenchmarkRuleGroups(b *testing.B) {
+	paths, err := filepath.Glob("../../../rules/*")
+	if err != nil {
+		b.Fatal(err)
+	}
+	detector, err := New(testLogger, config.ServiceFunctionT{RuleFiles: paths})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var requests []*dpipreprocessor.Request
+	for _, request := range benchmarkRequests {
+		data := request.preprocess()
+		defer data.Close()
+		requests = append(requests, data)
+	}
+
+	b.Run("automaton", func(b *testing.B) {
+		scratch := make([]int, detector.maxPatterns)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			request := requests[i%len(requests)]
+			for _, in := range requestInputs(request) {
+				for _, group := range detector.groups {
+					group.match(&in, request.Transformed(in.index, in.isName, group.view, group.transforms), scratch)
+				}
+			}
+		}
+	})
+	b.Run("sequential", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			request := requests[i%len(requests)]
+			for _, in := range requestInputs(request) {
+				for _, group := range detector.groups {
+					matchSequential(group, &in, request.Transformed(in.index, in.isName, group.view, group.transforms))
+				}
+			}
+		}
+	})
+}
+
+// matchSequential() matches every rule of the group on its own and evaluates every regular expression
+func matchSequential(group *ruleGroup, in *input, value string) (matches int) {
+	for _, rule := range group.literalRules {
+		phrases := rule.phrases
+		if rule.Type != RuleTypePhrases {
+			phrases = []string{rule.Pattern}
+		}
+		for _, phrase := range phrases {
+			if rule.appliesTo(in) && strings.Contains(value, phrase) {
+				matches++
+				break
+			}
+		}
+	}
+	for _, rule := range group.equalsRules {
+		if rule.appliesTo(in) && value == rule.Pattern {
+			matches++
+		}
+	}
+	for _, rule := range group.regexRules {
+		if rule.appliesTo(in) && rule.regex.MatchString(value) {
+			matches++
+		}
+	}
+	return matches
+}
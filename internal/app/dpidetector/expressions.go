@@ -0,0 +1,237 @@
+package dpidetector
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file detects injections of expressions, which are evaluated by Java applications. Log4j resolves lookups like
+"${lower:j}" in every logged string, so a JNDI lookup can be hidden behind any number of nested lookups. The lookups of
+a value are therefore resolved like Log4j does, before the result is checked for a JNDI lookup. Expression language
+injections into Spring (SpEL), Struts (OGNL) and JSP/JSF (EL) are recognized by the Java classes and objects they
+access. All values and names of a request are checked, including headers like User-Agent, which are logged frequently.
+*/
+
+// Transforms, which are applied to a value before its expressions are analyzed
+var expressionTransforms = []string{"urlDecode", "unicodeDecode", "removeNulls"}
+
+// Maximum nesting depth of Log4j lookups, which are resolved
+const maxLookupDepth = 32
+
+var (
+	jndiReferences = []string{
+		"https://logging.apache.org/log4j/2.x/security.html",
+		"https://nvd.nist.gov/vuln/detail/CVE-2021-44228",
+		"https://cwe.mitre.org/data/definitions/917.html",
+	}
+	elReferences = []string{
+		"https://owasp.org/www-community/vulnerabilities/Expression_Language_Injection",
+		"https://cwe.mitre.org/data/definitions/917.html",
+	}
+)
+
+var (
+	ruleJNDILookup = &Rule{
+		ID:         5001,
+		Msg:        "JNDI injection: Log4j JNDI lookup",
+		Category:   CategoryJNDIInjection,
+		Severity:   SeverityCritical,
+		References: jndiReferences,
+		Action:     ActionBlock,
+	}
+	ruleJNDIObfuscated = &Rule{
+		ID:         5002,
+		Msg:        "JNDI injection: Log4j JNDI lookup obfuscated by nested lookups",
+		Category:   CategoryJNDIInjection,
+		Severity:   SeverityCritical,
+		References: jndiReferences,
+		Action:     ActionBlock,
+	}
+	ruleELJavaAccess = &Rule{
+		ID:         5003,
+		Msg:        "EL injection: Java class or method access in an expression",
+		Category:   CategoryELInjection,
+		Severity:   SeverityCritical,
+		References: elReferences,
+		Action:     ActionBlock,
+	}
+	ruleELOGNL = &Rule{
+		ID:         5004,
+		Msg:        "EL injection: OGNL context or member access",
+		Category:   CategoryELInjection,
+		Severity:   SeverityCritical,
+		References: elReferences,
+		Action:     ActionBlock,
+	}
+	ruleELTypeReference = &Rule{
+		ID:         5005,
+		Msg:        "EL injection: SpEL type reference to a Java class",
+		Category:   CategoryELInjection,
+		Severity:   SeverityCritical,
+		References: elReferences,
+		Action:     ActionBlock,
+	}
+	ruleELImplicitObject = &Rule{
+		ID:         5006,
+		Msg:        "EL injection: implicit object of JSP or JSF in an expression",
+		Category:   CategoryELInjection,
+		Severity:   SeverityError,
+		References: elReferences,
+		Action:     ActionBlock,
+	}
+	ruleELProbe = &Rule{
+		ID:         5007,
+		Msg:        "EL injection: arithmetic expression probe",
+		Category:   CategoryELInjection,
+		Severity:   SeverityWarning,
+		References: elReferences,
+		Action:     ActionBlock,
+	}
+)
+
+// elPattern is a built-in expression language rule. Its regular expression is only evaluated for values, which
+// contain one of the indicator characters.
+type elPattern struct {
+	rule       *Rule
+	indicators string
+	regex      *regexp.Regexp
+}
+
+var elPatterns = []elPattern{
+	{ruleELJavaAccess, "{", regexp.MustCompile(`(?i)[$#%]\{[^}]*?(\bT\s*\(\s*[a-z_$][\w$]*\.|@java\.|java\.lang\.|` +
+		`\bnew\s+java\.|getruntime\s*\(|processbuilder|\.forname\s*\(|\.getclass\s*\(|classloader|` +
+		`scriptenginemanager|\.exec\s*\()`)},
+	{ruleELOGNL, "#@", regexp.MustCompile(`(?i)#_memberaccess|@ognl\.|#context\s*\[|#attr\s*\[|#application\s*\[|` +
+		`ognlutil|getexcludedclasses|getexcludedpackagenames|com\.opensymphony\.xwork2|struts\.valuestack`)},
+	{ruleELTypeReference, "(", regexp.MustCompile(`\bT\s*\(\s*(java|javax|jdk|sun|org\.springframework)\.[\w$.]+\s*\)`)},
+	{ruleELImplicitObject, "{", regexp.MustCompile(`(?i)[$#]\{\s*(pagecontext|applicationscope|sessionscope|` +
+		`requestscope|initparam|headervalues|paramvalues|facescontext|request\.getsession|session\.servletcontext)\b`)},
+	{ruleELProbe, "{", regexp.MustCompile(`[$#%*]\{\s*\d+\s*[-+*/]\s*\d+\s*\}`)},
+}
+
+// expressionRules() returns the built-in rules of the expression injection detection
+func expressionRules() []*Rule {
+	rules := []*Rule{ruleJNDILookup, ruleJNDIObfuscated}
+	for _, pattern := range elPatterns {
+		rules = append(rules, pattern.rule)
+	}
+	return rules
+}
+
+/*
+This function checks the values and names of all fields of the request for Log4j JNDI lookups and expression
+language injections.
+
+@param request: Preprocessed request
+@param inputs: Inputs of the request (see requestInputs())
+
+@return matches: Matches of the built-in JNDI and EL injection rules
+*/
+func detectExpressions(request *dpipreprocessor.Request, inputs []input) (matches []Match) {
+	for i := range inputs {
+		in := &inputs[i]
+		if in.explicit || !mayContainExpression(in.value) {
+			continue
+		}
+		value := request.Transformed(in.index, in.isName, dpipreprocessor.ViewNormalized, expressionTransforms)
+
+		if strings.Contains(value, "${") {
+			if lookups := jndiLookups(value); len(lookups) > 0 {
+				rule := ruleJNDIObfuscated
+				if strings.Contains(strings.ToLower(value), "${jndi:") {
+					rule = ruleJNDILookup
+				}
+				matches = append(matches, newMatch(rule, in.field, lookups[0], 0, len(lookups[0])))
+			}
+		}
+
+		for _, pattern := range elPatterns {
+			if !strings.ContainsAny(value, pattern.indicators) {
+				continue
+			}
+			if loc := pattern.regex.FindStringIndex(value); loc != nil {
+				matches = append(matches, newMatch(pattern.rule, in.field, value, loc[0], loc[1]))
+			}
+		}
+	}
+	return matches
+}
+
+// mayContainExpression() checks if a value contains a character, which starts an expression in one of its encodings.
+// Values without such characters are not transformed at all.
+func mayContainExpression(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == '{' || c == '(' || c == '#' || c == '@' || c == '%' || c == '\\' || c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+This function resolves the Log4j lookups of a value from the innermost to the outermost lookup. Lookups, which change
+the case of their key ("${lower:j}"), return a quoted date pattern ("${date:'j'}") or have a default value
+("${::-j}", "${env:UNSET:-j}"), are replaced with their result. Unresolvable lookups remain unchanged, like in Log4j.
+
+@param value: Transformed value
+
+@return lookups: JNDI lookups after the resolution, e.g. "${jndi:ldap://example.com/a}"
+*/
+func jndiLookups(value string) (lookups []string) {
+	// Every open lookup has a frame with its content resolved so far; the first frame is the value itself, whose
+	// content is not needed
+	frames := [][]byte{nil}
+	for i := 0; i < len(value); i++ {
+		top := len(frames) - 1
+		switch {
+		case value[i] == '$' && i+1 < len(value) && value[i+1] == '{' && top < maxLookupDepth:
+			frames = append(frames, []byte{})
+			i++
+		case value[i] == '}' && top > 0:
+			resolved, jndi := resolveLookup(string(frames[top]))
+			if jndi {
+				lookups = append(lookups, resolved)
+			}
+			frames = frames[:top]
+			if top > 1 {
+				frames[top-1] = append(frames[top-1], resolved...)
+			}
+		case top > 0:
+			frames[top] = append(frames[top], value[i])
+		}
+	}
+	return lookups
+}
+
+// resolveLookup() resolves a single lookup, whose nested lookups are already resolved. It reports if the lookup is a
+// JNDI lookup.
+func resolveLookup(content string) (resolved string, jndi bool) {
+	name, defaultValue, hasDefault := content, "", false
+	if i := strings.Index(content, ":-"); i >= 0 {
+		name, defaultValue, hasDefault = content[:i], content[i+2:], true
+	}
+	prefix, key := "", name
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		prefix, key = strings.ToLower(name[:i]), name[i+1:]
+	}
+
+	switch prefix {
+	case "jndi":
+		return "${" + content + "}", true
+	case "lower":
+		return strings.ToLower(key), false
+	case "upper":
+		return strings.ToUpper(key), false
+	case "date":
+		if len(key) >= 2 && key[0] == '\'' && key[len(key)-1] == '\'' {
+			return key[1 : len(key)-1], false
+		}
+	}
+	if hasDefault {
+		return defaultValue, false
+	}
+	return "${" + content + "}", false
+}
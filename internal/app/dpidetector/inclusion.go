@@ -0,0 +1,171 @@
+package dpidetector
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file detects local and remote file inclusion. In contrast to path traversal, the attacker does not need to leave
+the root directory: stream wrappers of PHP ("php://filter/...") read arbitrary files or execute code, URLs in
+parameters, which take file names, make the service include remote files, and the canonical path of a value is
+compared with well-known files of the operating system and of web applications.
+*/
+
+// Names of parameters, which take file names, when no file parameters are configured
+var DefaultFileParameters = []string{
+	"file", "filename", "filepath", "path", "page", "pg", "include", "inc", "require", "template", "tpl", "layout",
+	"view", "doc", "document", "folder", "dir", "root", "style", "pdf", "lang", "language", "module", "mod", "conf",
+	"config",
+}
+
+// Configuration files of web applications, which must never be accessed by a request
+var applicationFiles = []string{
+	"web.config",
+	"web-inf/web.xml",
+	"meta-inf/context.xml",
+	".htaccess",
+	".htpasswd",
+	".env",
+	"wp-config.php",
+	".git/config",
+	".aws/credentials",
+}
+
+// Stream wrappers of PHP, which read local files, the request body or archives, or execute commands
+var phpWrapper = regexp.MustCompile(`(^|[^a-z0-9.])(php://(filter|input|stdin|fd|memory|temp)|` +
+	`(data|expect|zip|phar|glob|ogg|rar|zlib|compress\.zlib|compress\.bzip2|ssh2\.(shell|exec|tunnel|sftp|scp))://)`)
+
+// Remote file: URL of a remote file or UNC path of a share
+var remoteFile = regexp.MustCompile(`^\s*((https?|ftps?|smb|webdav)://|//|\\{2,})[^/\\\s]`)
+
+var inclusionReferences = []string{
+	"https://capec.mitre.org/data/definitions/252.html",
+	"https://capec.mitre.org/data/definitions/193.html",
+	"https://cwe.mitre.org/data/definitions/98.html",
+}
+
+var (
+	rulePHPWrapper = &Rule{
+		ID:         1101,
+		Msg:        "File inclusion: PHP stream wrapper",
+		Category:   CategoryFileInclusion,
+		Severity:   SeverityCritical,
+		References: inclusionReferences,
+		Action:     ActionBlock,
+	}
+	ruleRemoteInclusion = &Rule{
+		ID:         1102,
+		Msg:        "File inclusion: remote file in a file parameter",
+		Category:   CategoryFileInclusion,
+		Severity:   SeverityCritical,
+		References: inclusionReferences,
+		Action:     ActionBlock,
+	}
+	ruleIncludedSensitiveFile = &Rule{
+		ID:         1103,
+		Msg:        "File inclusion: sensitive file in a file parameter",
+		Category:   CategoryFileInclusion,
+		Severity:   SeverityCritical,
+		References: inclusionReferences,
+		Action:     ActionBlock,
+	}
+	ruleApplicationFile = &Rule{
+		ID:         1104,
+		Msg:        "File inclusion: access to a configuration file of the web application",
+		Category:   CategoryFileInclusion,
+		Severity:   SeverityError,
+		References: inclusionReferences,
+		Action:     ActionBlock,
+	}
+)
+
+// inclusionRules() returns the built-in rules of the file inclusion detection
+func inclusionRules() []*Rule {
+	return []*Rule{rulePHPWrapper, ruleRemoteInclusion, ruleIncludedSensitiveFile, ruleApplicationFile}
+}
+
+// fileParameterSet() converts the configured file parameters into a set of lower case names. Without file
+// parameters the DefaultFileParameters are used.
+func fileParameterSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		names = DefaultFileParameters
+	}
+	set := make(map[string]bool)
+	for _, name := range names {
+		set[strings.ToLower(name)] = true
+	}
+	return set
+}
+
+// parameterName() returns the last key of the flattened name of an argument without array indexes, e.g. "file" for
+// "pages[0].file", "data[file]" or "file[]"
+func parameterName(name string) string {
+	name = strings.ToLower(name)
+	for strings.HasSuffix(name, "]") {
+		open := strings.LastIndexByte(name, '[')
+		if open < 0 || strings.Trim(name[open+1:len(name)-1], "0123456789") != "" {
+			break
+		}
+		name = name[:open]
+	}
+	name = strings.TrimSuffix(name, "]")
+	return name[strings.LastIndexAny(name, ".[")+1:]
+}
+
+/*
+This method checks the path and the values of all arguments of the request for file inclusions. Stream wrappers are
+reported in every argument, remote and sensitive files only in file parameters, while configuration files of web
+applications must neither be requested nor be referred to by any argument.
+
+@param request: Preprocessed request
+@param inputs: Inputs of the request (see requestInputs())
+
+@return matches: Matches of the built-in file inclusion rules
+*/
+func (detector *Detector) detectInclusion(request *dpipreprocessor.Request, inputs []input) (matches []Match) {
+	for i := range inputs {
+		in := &inputs[i]
+		isArg := in.belongsTo(TargetArgs)
+		if in.isName || !(isArg || in.belongsTo(TargetPath)) || !strings.ContainsAny(in.value, "./\\:%") {
+			continue
+		}
+		value := request.Transformed(in.index, false, dpipreprocessor.ViewNormalized, traversalTransforms)
+		if i := strings.IndexByte(value, 0); i >= 0 {
+			value = value[:i]
+		}
+
+		fileParameter := isArg && detector.fileParameters[parameterName(in.name)]
+		if isArg && strings.Contains(value, "://") {
+			if loc := phpWrapper.FindStringSubmatchIndex(value); loc != nil {
+				matches = append(matches, newMatch(rulePHPWrapper, in.field, value, loc[4], loc[5]))
+			}
+		}
+		if fileParameter {
+			if loc := remoteFile.FindStringSubmatchIndex(value); loc != nil {
+				matches = append(matches, newMatch(ruleRemoteInclusion, in.field, value, loc[2], len(value)))
+			}
+		}
+
+		path := comparablePath(value)
+		switch {
+		case fileParameter && (containsFile(detector.sensitiveFiles, path) || containsFile(applicationFiles, path)):
+			matches = append(matches, newMatch(ruleIncludedSensitiveFile, in.field, value, 0, len(value)))
+		case containsFile(applicationFiles, path):
+			matches = append(matches, newMatch(ruleApplicationFile, in.field, value, 0, len(value)))
+		}
+	}
+	return matches
+}
+
+// containsFile() checks if a comparable path (see comparablePath()) refers to one of the files
+func containsFile(files []string, path string) bool {
+	for _, file := range files {
+		if path == file || strings.HasSuffix(path, "/"+file) {
+			return true
+		}
+	}
+	return false
+}
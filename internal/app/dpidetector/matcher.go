@@ -0,0 +1,357 @@
+package dpidetector
+
+import (
+	"regexp/syntax"
+	"strings"
+)
+
+/*
+This file implements the matching engine of the Detector. All rules are compiled once at load time into rule groups.
+A rule group contains all rules, which apply the same transforms to the same view of the fields, so an input is
+transformed only once per group.
+The patterns of all literal and phrase rules and the literals required by the regular expressions of a group are
+compiled into one Aho-Corasick automaton (see ahocorasick.go), which finds all of them in a single pass over the input. A regular
+expression is only evaluated, if the input contains at least one of its required literals.
+*/
+
+type ruleGroup struct {
+	view       string
+	transforms []string
+
+	// Literal and phrase rules
+	literalRules []*Rule
+	// Indexes of the patterns of every literal rule in the automaton
+	literalPatterns [][]int
+
+	regexRules []*Rule
+	// Indexes of the required literals of every regex rule in the automaton.
+	// A rule without required literals is always evaluated.
+	regexLiterals [][]int
+
+	equalsRules []*Rule
+
+	// Explicit targets (see explicitTargets), which are named by a rule of the group; other groups skip their inputs
+	explicitTargets map[string]bool
+
+	automaton *ahoCorasick
+}
+
+// newRuleGroups() sorts the rules into groups of identical views and transforms and compiles their automatons
+func newRuleGroups(rules []*Rule) []*ruleGroup {
+	var groups []*ruleGroup
+	byTransforms := make(map[string]*ruleGroup)
+	for _, head := range rules {
+		for rule := head; rule != nil; rule = rule.Chain { // The conditions of a chain are matched like any other rule
+			key := rule.View + ":" + strings.Join(rule.Transforms, ",")
+			group, ok := byTransforms[key]
+			if !ok {
+				group = &ruleGroup{view: rule.View, transforms: rule.Transforms}
+				byTransforms[key] = group
+				groups = append(groups, group)
+			}
+			group.add(rule)
+		}
+	}
+
+	for _, group := range groups {
+		group.compile()
+	}
+	return groups
+}
+
+func (group *ruleGroup) add(rule *Rule) {
+	for _, target := range rule.targets {
+		if explicitTargets[target.collection] && !target.exclude {
+			if group.explicitTargets == nil {
+				group.explicitTargets = make(map[string]bool)
+			}
+			group.explicitTargets[target.collection] = true
+		}
+	}
+
+	switch rule.Type {
+	case RuleTypeRegex:
+		group.regexRules = append(group.regexRules, rule)
+	case RuleTypeEquals:
+		group.equalsRules = append(group.equalsRules, rule)
+	default:
+		group.literalRules = append(group.literalRules, rule)
+	}
+}
+
+// compile() builds the automaton from the literal patterns of the group. Identical literals are searched only once.
+func (group *ruleGroup) compile() {
+	var patterns []string
+	index := make(map[string]int)
+	patternIndex := func(literal string) int {
+		if _, ok := index[literal]; !ok {
+			index[literal] = len(patterns)
+			patterns = append(patterns, literal)
+		}
+		return index[literal]
+	}
+
+	group.literalPatterns = make([][]int, len(group.literalRules))
+	for i, rule := range group.literalRules {
+		if rule.Type == RuleTypePhrases {
+			for _, phrase := range rule.phrases {
+				group.literalPatterns[i] = append(group.literalPatterns[i], patternIndex(phrase))
+			}
+		} else {
+			group.literalPatterns[i] = []int{patternIndex(rule.Pattern)}
+		}
+	}
+
+	group.regexLiterals = make([][]int, len(group.regexRules))
+	for i, rule := range group.regexRules {
+		for _, literal := range requiredLiterals(rule.Pattern) {
+			group.regexLiterals[i] = append(group.regexLiterals[i], patternIndex(literal))
+		}
+	}
+
+	group.automaton = newAhoCorasick(patterns)
+}
+
+// match() applies all rules of the group targeting the input to its already transformed value. The scratch buffer
+// is used for the offsets of the automaton (see maxPatterns()).
+func (group *ruleGroup) match(in *input, value string, scratch []int) (matches []Match) {
+	if in.explicit && !group.explicitTargets[in.targets[0]] {
+		return nil
+	}
+	offsets := group.automaton.firstOffsets(value, scratch)
+
+	for i, rule := range group.literalRules {
+		if !rule.appliesTo(in) {
+			continue
+		}
+		// The earliest occurrence of all patterns of the rule is reported
+		start, end := -1, -1
+		for _, pattern := range group.literalPatterns[i] {
+			offset := offsets[pattern]
+			if offset >= 0 && (start < 0 || offset < start) {
+				start, end = offset, offset+group.automaton.lengths[pattern]
+			}
+		}
+		if start >= 0 {
+			matches = append(matches, newMatch(rule, in.field, value, start, end))
+		}
+	}
+
+	for _, rule := range group.equalsRules {
+		if value == rule.Pattern && rule.appliesTo(in) {
+			matches = append(matches, newMatch(rule, in.field, value, 0, len(value)))
+		}
+	}
+
+	for i, rule := range group.regexRules {
+		if !rule.appliesTo(in) || !anyPresent(offsets, group.regexLiterals[i]) {
+			continue
+		}
+		if loc := rule.regex.FindStringIndex(value); loc != nil {
+			matches = append(matches, newMatch(rule, in.field, value, loc[0], loc[1]))
+		}
+	}
+	return matches
+}
+
+// resolveChains() removes the matches of chain conditions and of rules, whose chain did not match completely
+func resolveChains(matches []Match) (resolved []Match) {
+	matched := make(map[*Rule]bool)
+	for _, match := range matches {
+		matched[match.Rule] = true
+	}
+
+	for _, match := range matches {
+		if match.Rule.chained {
+			continue
+		}
+		complete := true
+		for link := match.Rule.Chain; link != nil && complete; link = link.Chain {
+			complete = matched[link]
+		}
+		if complete {
+			resolved = append(resolved, match)
+		}
+	}
+	return resolved
+}
+
+// maxPatterns() returns the largest number of patterns of the automatons of the groups
+func maxPatterns(groups []*ruleGroup) (max int) {
+	for _, group := range groups {
+		if len(group.automaton.lengths) > max {
+			max = len(group.automaton.lengths)
+		}
+	}
+	return max
+}
+
+func anyPresent(offsets []int, literals []int) bool {
+	if len(literals) == 0 {
+		return true
+	}
+	for _, literal := range literals {
+		if offsets[literal] >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+This function extracts a set of literals from a regular expression, of which at least one is contained in every
+string matched by the expression.
+
+@param pattern: Regular expression of a rule
+
+@return literals: Required literals; nil, when no such set could be determined
+*/
+func requiredLiterals(pattern string) []string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return literalSet(re.Simplify())
+}
+
+// Maximum number of characters of a character class, which is expanded into single character literals
+const maxClassLiterals = 8
+
+func literalSet(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil
+		}
+		return []string{string(re.Rune)}
+	case syntax.OpCharClass:
+		return classLiterals(re)
+	case syntax.OpCapture, syntax.OpPlus:
+		return literalSet(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return nil
+		}
+		return literalSet(re.Sub[0])
+	case syntax.OpAlternate:
+		var literals []string
+		for _, sub := range re.Sub {
+			subLiterals := literalSet(sub)
+			if subLiterals == nil {
+				return nil
+			}
+			literals = append(literals, subLiterals...)
+		}
+		return literals
+	case syntax.OpConcat:
+		return concatLiteralSet(re.Sub)
+	}
+	return nil
+}
+
+// concatLiteralSet() chooses the most selective literal set of all parts of a concatenation.
+// Adjacent literal parts are joined to a longer literal. A literal followed by a part, which starts with one of a few
+// prefixes like "c['"]+", is joined to a set of longer literals, too.
+func concatLiteralSet(subs []*syntax.Regexp) (best []string) {
+	var run []rune
+	consider := func(literals []string) {
+		if literals != nil && (best == nil || selectivity(literals) > selectivity(best)) {
+			best = literals
+		}
+	}
+
+	for _, sub := range subs {
+		if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 {
+			run = append(run, sub.Rune...)
+			continue
+		}
+		if len(run) > 0 {
+			consider([]string{string(run)})
+			if prefixes := prefixLiterals(sub); prefixes != nil {
+				joined := make([]string, len(prefixes))
+				for i, prefix := range prefixes {
+					joined[i] = string(run) + prefix
+				}
+				consider(joined)
+			}
+			run = nil
+		}
+		consider(literalSet(sub))
+	}
+	if len(run) > 0 {
+		consider([]string{string(run)})
+	}
+	return best
+}
+
+// Maximum number of prefixes, which are joined with a literal
+const maxPrefixLiterals = 256
+
+// prefixLiterals() returns a set of literals, with one of which every string matched by the expression starts;
+// nil, when there is no such set
+func prefixLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil
+		}
+		return []string{string(re.Rune)}
+	case syntax.OpCharClass:
+		return classLiterals(re)
+	case syntax.OpCapture, syntax.OpPlus:
+		return prefixLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return nil
+		}
+		return prefixLiterals(re.Sub[0])
+	case syntax.OpConcat:
+		prefixes := prefixLiterals(re.Sub[0])
+		// A literal is continued by the prefixes of the next part
+		if re.Sub[0].Op == syntax.OpLiteral && prefixes != nil && len(re.Sub) > 1 {
+			if next := prefixLiterals(re.Sub[1]); next != nil && len(next) <= maxPrefixLiterals {
+				joined := make([]string, 0, len(next))
+				for _, suffix := range next {
+					joined = append(joined, prefixes[0]+suffix)
+				}
+				return joined
+			}
+		}
+		return prefixes
+	case syntax.OpAlternate:
+		var prefixes []string
+		for _, sub := range re.Sub {
+			subPrefixes := prefixLiterals(sub)
+			if subPrefixes == nil || len(prefixes)+len(subPrefixes) > maxPrefixLiterals {
+				return nil
+			}
+			prefixes = append(prefixes, subPrefixes...)
+		}
+		return prefixes
+	}
+	return nil
+}
+
+// classLiterals() expands a small character class into its characters
+func classLiterals(re *syntax.Regexp) (literals []string) {
+	for i := 0; i < len(re.Rune); i += 2 {
+		for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+			if len(literals) == maxClassLiterals {
+				return nil
+			}
+			literals = append(literals, string(r))
+		}
+	}
+	return literals
+}
+
+// selectivity() rates a literal set by its shortest literal. Longer literals occur less often in benign inputs.
+func selectivity(literals []string) int {
+	shortest := len(literals[0])
+	for _, literal := range literals[1:] {
+		if len(literal) < shortest {
+			shortest = len(literal)
+		}
+	}
+	return shortest
+}
@@ -0,0 +1,86 @@
+package dpidetector
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+func TestRequiredLiterals(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+		matches []string // Inputs matched by the pattern, which must contain one of the literals
+	}{
+		{`\.\./`, []string{"../"}, []string{"a/../b"}},
+		{`<script[^>]*>`, []string{"<script"}, []string{"<script>", "x<script src=a>"}},
+		{`on(load|error|click)\s*=`, []string{"onload", "onerror", "onclick"}, []string{"onerror =", "onclick="}},
+		{`union\s+(all\s+)?select`, []string{"union\t", "union\n", "union\f", "union\r", "union "},
+			[]string{"union select", "union\tall select"}},
+		{`(?:cmd|powershell)\.exe`, []string{".exe"}, []string{"cmd.exe", "powershell.exe"}},
+		{`\$\{jndi:`, []string{"${jndi:"}, []string{"${jndi:ldap://a"}},
+		{`exec\s*['"]`, []string{"exec"}, []string{"exec'", "exec  \""}},
+		{`x[0-9]+`, []string{"x"}, []string{"x1", "ax42"}},
+		// Case insensitive literals and optional parts cannot prefilter a rule
+		{`(?i)select`, nil, []string{"SeLeCt"}},
+		{`a*b?`, nil, []string{""}},
+		{`a|.*`, nil, []string{"b"}},
+		{`[`, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			literals := requiredLiterals(test.pattern)
+			if len(literals) == 0 {
+				literals = nil
+			}
+			if !reflect.DeepEqual(literals, test.want) {
+				t.Errorf("literals = %q, want %q", literals, test.want)
+			}
+			if literals == nil {
+				return
+			}
+			re := regexp.MustCompile(test.pattern)
+			for _, input := range test.matches {
+				contained := false
+				for _, literal := range literals {
+					contained = contained || strings.Contains(input, literal)
+				}
+				if !re.MatchString(input) || !contained {
+					t.Errorf("input %q is matched without a required literal", input)
+				}
+			}
+		})
+	}
+}
+
+// Regex rules are only evaluated, when one of their required literals occurs, but still match like without prefilter
+func TestRegexPrefilter(t *testing.T) {
+	rules := `rules:
+  - {id: 1000001, category: sql_injection, severity: critical, type: regex, pattern: 'union\s+(all\s+)?select'}
+  - {id: 1000002, category: xss, severity: critical, type: regex, pattern: '(?i)<script'}
+  - {id: 1000003, category: xss, severity: critical, type: literal, pattern: 'javascript:'}
+`
+	detector := newTestDetector(t, "test.yml", rules, config.ServiceFunctionT{})
+
+	tests := []struct {
+		name   string
+		target string
+		want   []int
+	}{
+		{"prefiltered regex", "/?q=1%20union%20all%20select%202", []int{1000001}},
+		{"regex in a longer word", "/?q=union%20selection", []int{1000001}},
+		{"literal only", "/?q=union%20of%20select", nil},
+		{"regex without literals", "/?q=%3CScRiPt%3E", []int{1000002}},
+		{"literal rule", "/?q=javascript:alert(1)", []int{1000003}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ids := detect(t, detector, testRequest{target: test.target}); !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
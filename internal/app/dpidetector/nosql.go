@@ -0,0 +1,150 @@
+package dpidetector
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file detects NoSQL injection into MongoDB queries. Frameworks like Express turn the query argument
+"user[$ne]=x" into the object {"user": {"$ne": "x"}}, so an attacker can replace a scalar value, which the service
+passes into a query, with a query operator. The keys of the structured arguments of query strings, forms and JSON
+bodies are therefore checked for query operators. Besides, JavaScript in $where clauses, values of $where clauses
+breaking out of a JavaScript string and operator objects in string values, which are parsed as JSON by the service, are
+reported.
+*/
+
+// Transforms, which are applied to names and values before they are checked for NoSQL injection
+var nosqlTransforms = []string{"urlDecode", "unicodeDecode", "removeNulls"}
+
+// Query operators of MongoDB in lower case, like the names of arguments. "$type" is missing on purpose, because it
+// names the type of an object in the JSON documents of .NET services.
+var mongoOperators = map[string]bool{
+	"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true, "$in": true, "$nin": true,
+	"$and": true, "$or": true, "$nor": true, "$not": true, "$exists": true, "$expr": true, "$regex": true,
+	"$where": true, "$text": true, "$mod": true, "$all": true, "$size": true, "$elemmatch": true, "$jsonschema": true,
+	"$function": true, "$accumulator": true,
+}
+
+// Operators, whose values are JavaScript, which is executed by the database
+var javaScriptOperators = map[string]bool{"$where": true, "$function": true, "$accumulator": true}
+
+var (
+	// JavaScript in the value of a $where clause
+	whereJavaScript = regexp.MustCompile(`(?i)\bfunction\b|\bthis\.|\bsleep\s*\(|\bwhile\s*\(|\breturn\b|\bdb\.|` +
+		`\bobj\.|\bemit\s*\(|==|\|\||&&|;`)
+	// Object with a query operator in a string value, e.g. {"$ne": null}
+	operatorObject = regexp.MustCompile(`(?i)\{\s*["']?\$(eq|ne|gte?|lte?|n?in|and|or|nor|not|exists|expr|regex|` +
+		`where|elemmatch|function)["']?\s*:`)
+	// Breakout of a JavaScript string in a $where clause, e.g. "' || '1'=='1" or "';sleep(5000);'"
+	whereBreakout = regexp.MustCompile(`['"]\s*(\|\||&&|;)\s*(this\.\w|sleep\s*\(|return\b|true\b|` +
+		`\(?\s*['"]?\w*['"]?\s*===?)`)
+)
+
+var nosqlReferences = []string{
+	"https://capec.mitre.org/data/definitions/676.html",
+	"https://cwe.mitre.org/data/definitions/943.html",
+}
+
+var (
+	ruleNoSQLOperator = &Rule{
+		ID:         2101,
+		Msg:        "NoSQL injection: query operator in the name of an argument",
+		Category:   CategoryNoSQLInjection,
+		Severity:   SeverityCritical,
+		References: nosqlReferences,
+		Action:     ActionBlock,
+	}
+	ruleNoSQLJavaScript = &Rule{
+		ID:         2102,
+		Msg:        "NoSQL injection: JavaScript in a $where clause",
+		Category:   CategoryNoSQLInjection,
+		Severity:   SeverityCritical,
+		References: nosqlReferences,
+		Action:     ActionBlock,
+	}
+	ruleNoSQLOperatorObject = &Rule{
+		ID:         2103,
+		Msg:        "NoSQL injection: query operator object in a value",
+		Category:   CategoryNoSQLInjection,
+		Severity:   SeverityError,
+		References: nosqlReferences,
+		Action:     ActionBlock,
+	}
+	ruleNoSQLWhereBreakout = &Rule{
+		ID:         2104,
+		Msg:        "NoSQL injection: breakout of a JavaScript string in a $where clause",
+		Category:   CategoryNoSQLInjection,
+		Severity:   SeverityError,
+		References: nosqlReferences,
+		Action:     ActionBlock,
+	}
+)
+
+// nosqlRules() returns the built-in rules of the NoSQL injection detection
+func nosqlRules() []*Rule {
+	return []*Rule{ruleNoSQLOperator, ruleNoSQLJavaScript, ruleNoSQLOperatorObject, ruleNoSQLWhereBreakout}
+}
+
+/*
+This function checks the names and values of all structured arguments of the request for NoSQL injection. The name
+of an argument is split into its keys, e.g. "user[$ne]" or "password.$gt", of which none may be a query operator.
+
+@param request: Preprocessed request
+@param inputs: Inputs of the request (see requestInputs())
+
+@return matches: Matches of the built-in NoSQL injection rules
+*/
+func detectNoSQL(request *dpipreprocessor.Request, inputs []input) (matches []Match) {
+	for i := range inputs {
+		in := &inputs[i]
+		if !in.belongsTo(TargetArgs) && !in.belongsTo(TargetArgsNames) {
+			continue
+		}
+
+		if in.isName {
+			if !strings.ContainsAny(in.value, "$%＄") {
+				continue
+			}
+			name := request.Transformed(in.index, true, dpipreprocessor.ViewNormalized, nosqlTransforms)
+			for _, key := range argumentKeys(name) {
+				if mongoOperators[key] {
+					start := strings.Index(name, key)
+					matches = append(matches, newMatch(ruleNoSQLOperator, in.field, name, start, start+len(key)))
+					break
+				}
+			}
+			continue
+		}
+
+		keys := argumentKeys(in.name)
+		javaScript := len(keys) > 0 && javaScriptOperators[keys[len(keys)-1]]
+		if !javaScript && !strings.ContainsAny(in.value, "{'\"%\\＄") {
+			continue
+		}
+		value := request.Transformed(in.index, false, dpipreprocessor.ViewNormalized, nosqlTransforms)
+		if javaScript {
+			if loc := whereJavaScript.FindStringIndex(value); loc != nil {
+				matches = append(matches, newMatch(ruleNoSQLJavaScript, in.field, value, loc[0], loc[1]))
+			}
+			// Outside of JavaScript a quote followed by "||" or ";" is ordinary text
+			if loc := whereBreakout.FindStringIndex(value); loc != nil {
+				matches = append(matches, newMatch(ruleNoSQLWhereBreakout, in.field, value, loc[0], loc[1]))
+			}
+		}
+		if loc := operatorObject.FindStringIndex(value); loc != nil {
+			matches = append(matches, newMatch(ruleNoSQLOperatorObject, in.field, value, loc[0], loc[1]))
+		}
+	}
+	return matches
+}
+
+// argumentKeys() splits the flattened name of an argument into its keys, e.g. "user[$ne]" into "user" and "$ne" and
+// "items[0].price.$gt" into "items", "0", "price" and "$gt"
+func argumentKeys(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return r == '.' || r == '[' || r == ']'
+	})
+}
@@ -0,0 +1,44 @@
+package dpidetector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+func TestDetectNoSQL(t *testing.T) {
+	detector := newTestDetector(t, "", "", config.ServiceFunctionT{})
+	json := map[string]string{"Content-Type": "application/json"}
+
+	tests := []struct {
+		name    string
+		request testRequest
+		want    []int
+	}{
+		{"operator in query argument", testRequest{target: "/login?user=admin&password[$ne]=x"}, []int{2101}},
+		{"encoded operator", testRequest{target: "/login?password%255B%2524ne%255D=x"}, []int{2101}},
+		{"fullwidth dollar", testRequest{target: "/login?password[%EF%BC%84gt]="}, []int{2101}},
+		{"operator in JSON body", testRequest{method: "POST", target: "/login", headers: json,
+			body: `{"user": "admin", "password": {"$ne": null}}`}, []int{2101}},
+		{"JavaScript in $where", testRequest{method: "POST", target: "/", headers: json,
+			body: `{"$where": "this.role == 'admin'"}`}, []int{2101, 2102}},
+		{"breakout in $where", testRequest{method: "POST", target: "/", headers: json,
+			body: `{"$where": "'; sleep(5000); var x='"}`}, []int{2101, 2102, 2104}},
+		{"operator object in string", testRequest{target: "/?filter=%7B%22%24ne%22%3A1%7D"}, []int{2103}},
+		{"quote outside of $where", testRequest{target: "/?q=%22no%22%3B%20return%20tomorrow"}, nil},
+		{"breakout outside of $where", testRequest{target: "/?user=admin%27%20%7C%7C%20%271%27%3D%3D%271"}, nil},
+		{"bracket argument", testRequest{target: "/users?sort[price]=asc&page[size]=10"}, nil},
+		{"$type of .NET", testRequest{method: "POST", target: "/", headers: json,
+			body: `{"$type": "MyApp.Order, MyApp", "id": 1}`}, nil},
+		{"dollar in value", testRequest{target: "/?price=%245"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids := categoryIDs(detect(t, detector, test.request), CategoryNoSQLInjection)
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
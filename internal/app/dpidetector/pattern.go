@@ -1,31 +1,206 @@
 package dpidetector
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
 /*
-This file represents the signatures for the Detector. Here the patterns and regular expressions are defined, which are
-used by the Detector, to check if a request is malicious.
+This file represents the signatures for the Detector. Every signature is described by a Rule, which carries the
+metadata that is reported together with a match. The rules themselves are not compiled into the binary, but loaded
+from rule files at startup (see rules.go).
 */
 
-// Patterns for Path Traversal
-var patternPathTrav = [1]string{
-	"../",
+// Rule categories, which can be addressed individually in the enforcement configuration
+const (
+	CategoryPathTraversal    = "path_traversal"
+	CategoryFileInclusion    = "file_inclusion"
+	CategorySQLInjection     = "sql_injection"
+	CategoryNoSQLInjection   = "nosql_injection"
+	CategoryFileUpload       = "file_upload"
+	CategoryXXE              = "xxe"
+	CategoryXSS              = "xss"
+	CategoryCommandInjection = "command_injection"
+	CategoryJNDIInjection    = "jndi_injection"
+	CategoryELInjection      = "el_injection"
+	CategorySSRF             = "ssrf"
+)
+
+// Rule types, which define how the pattern of a rule is matched:
+// "literal" searches the pattern, "regex" evaluates it as regular expression,
+// "phrases" searches all space separated words of the pattern and "equals" compares the whole input with the pattern
+const (
+	RuleTypeLiteral = "literal"
+	RuleTypeRegex   = "regex"
+	RuleTypePhrases = "phrases"
+	RuleTypeEquals  = "equals"
+)
+
+// Rule actions: "block" makes a match relevant for the enforcement, "pass" only logs it and adds to the anomaly score
+const (
+	ActionBlock = "block"
+	ActionPass  = "pass"
+)
+
+// Severity of a rule. A higher value means a more severe finding.
+type Severity int
+
+const (
+	SeverityNotice Severity = iota + 1
+	SeverityWarning
+	SeverityError
+	SeverityCritical
+)
+
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityNotice:
+		return "notice"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	case SeverityCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// ParseSeverity() converts the name of a severity into a Severity
+func ParseSeverity(name string) (Severity, error) {
+	for severity := SeverityNotice; severity <= SeverityCritical; severity++ {
+		if strings.EqualFold(name, severity.String()) {
+			return severity, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity '%s'", name)
+}
+
+// Rule describes a single signature and its metadata
+type Rule struct {
+	ID         int
+	Msg        string
+	Category   string
+	Severity   Severity
+	References []string
+	Type       string
+	Pattern    string
+	Targets    []string
+	// View of the fields, to which the transforms are applied: the normalized (decoded) fields by default or the raw
+	// fields as received. Fields are not converted to lower case, case insensitive rules use the lowercase transform.
+	View       string
+	Transforms []string
+	Action     string
+	// Anomaly score, which a match of the rule adds to the score of the request.
+	// Without a score the configured score of the severity is used.
+	Score int
+
+	// Further condition, which has to match in the same request for the rule to match.
+	// Rules imported from other rule languages use chains for signatures with several patterns.
+	Chain *Rule
+
+	regex   *regexp.Regexp
+	phrases []string
+	targets []ruleTarget
+	chained bool // The rule is part of the chain of another rule and does not match on its own
+}
+
+// Match is the result of a rule matching a part of a request
+type Match struct {
+	RuleID  int
+	Rule    *Rule
+	Field   string // Request field, in which the rule matched
+	Matched string // Matched substring of the field
+	Offset  int    // Byte offset of the matched substring in the field
+}
+
+// compile() validates the rule, sets the defaults of optional attributes and compiles the pattern
+func (rule *Rule) compile() error {
+	if rule.ID <= 0 {
+		return fmt.Errorf("rule id must be a positive number")
+	}
+	if rule.Category == "" {
+		return fmt.Errorf("rule %d: no category defined", rule.ID)
+	}
+	if rule.Pattern == "" {
+		return fmt.Errorf("rule %d: no pattern defined", rule.ID)
+	}
+
+	if rule.Action == "" {
+		rule.Action = ActionBlock
+	}
+	if rule.Action != ActionBlock && rule.Action != ActionPass {
+		return fmt.Errorf("rule %d: unknown action '%s'", rule.ID, rule.Action)
+	}
+	if rule.Score < 0 {
+		return fmt.Errorf("rule %d: score must not be negative", rule.ID)
+	}
+
+	rule.targets = nil
+	for _, text := range rule.Targets {
+		target, err := parseTarget(text)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", rule.ID, err)
+		}
+		rule.targets = append(rule.targets, target)
+	}
+
+	if rule.View == "" {
+		rule.View = dpipreprocessor.ViewNormalized
+	}
+	if rule.View != dpipreprocessor.ViewNormalized && rule.View != dpipreprocessor.ViewRaw {
+		return fmt.Errorf("rule %d: unknown view '%s'", rule.ID, rule.View)
+	}
+
+	for _, transform := range rule.Transforms {
+		if !dpipreprocessor.IsTransform(transform) {
+			return fmt.Errorf("rule %d: unknown transform '%s'", rule.ID, transform)
+		}
+	}
+
+	switch rule.Type {
+	case RuleTypeLiteral, RuleTypeEquals:
+	case RuleTypePhrases:
+		rule.phrases = strings.Fields(rule.Pattern)
+		if len(rule.phrases) == 0 {
+			return fmt.Errorf("rule %d: no phrases defined", rule.ID)
+		}
+	case RuleTypeRegex:
+		regex, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", rule.ID, err)
+		}
+		rule.regex = regex
+	default:
+		return fmt.Errorf("rule %d: unknown type '%s'", rule.ID, rule.Type)
+	}
+
+	if rule.Chain != nil {
+		rule.Chain.ID = rule.ID
+		rule.Chain.Category = rule.Category
+		rule.Chain.chained = true
+		return rule.Chain.compile()
+	}
+	return nil
 }
 
-// Regular Expressions for SQL Injection
-var regexSQLInject = [17]string{
-	"('|[0-9]+)(\\s)+(--|;)", // PATTERN CHANGED FROM ORIGINALLY:  "('|[0-9]+)(\\s)*(--|;)"
-	"'\\s*or\\s+[a-z0-9]+\\s*=\\s*[a-z0-9]+\\s*(--|;)",
-	"[0-9]+\\s*or\\s+[a-z0-9]+\\s*=\\s*[a-z0-9]+",
-	"'\\s*union(\\s+all)?\\s+select[ a-z0-9'\"\\*,_\\(\\)\\-]+from[ a-z0-9\\-_\\(\\)\\-]+(--|;)",
-	"[0-9]+\\s+union(\\s+all)?\\s+select[ a-z0-9'\"\\*,_\\(\\)\\-]+from[ a-z0-9\\-_\\(\\)\\-]+",
-	";\\s*select[ a-z0-9'\"\\*,_\\(\\)\\-]+from[ a-z0-9\\-_\\(\\)\\-]+(--|;)",
-	";\\s*insert\\s+into\\s+[ a-z0-9\\-_\\(\\)\\-].*\\s+values\\s*\\(([ a-z0-9'\"\\*,_\\(\\)\\-]+\\s*,\\s*)*[ a-z0-9'\"\\*_\\(\\)\\-]+\\)\\s*(--|;)",
-	";\\s*insert\\s+into\\s+[ a-z0-9\\-_\\(\\)\\-].*\\s+select[ a-z0-9'\"\\*,_\\(\\)\\-]+from[ a-z0-9\\-_\\(\\)\\-]+(--|;)",
-	";\\s*update\\s+[ a-z0-9\\-_\\(\\)\\-]+\\s+set(\\s+[a-z0-9\\-_]+\\s+=\\s*.+\\s*,)*\\s+[a-z0-9\\-_\\-]+\\s*=\\s*.+\\s*(--|;)",
-	";\\s*delete\\s+from\\s+[ a-z0-9\\-_\\(\\)\\-]+\\s*.*(--|;)",
-	";\\s*drop\\s+(table|view|index)\\s+[ a-z0-9\\-_\\(\\)\\-]+(--|;)",
-	";\\s*truncate\\s+table\\s+[ a-z0-9\\-_\\(\\)\\-]+(--|;)",
-	";\\s*alter\\s+table\\s+[ a-z0-9\\-_\\(\\)\\-]+(\\s)+(add|drop\\s+column|alter\\s+column|modify|rename\\s+column)(\\s)+.+(--|;)",
-	";\\s*create\\s+table\\s+[ a-z0-9\\-_\\(\\)\\-]+\\s*\\((\\s*[a-z0-9\\-_]+\\s+[ a-z0-9_\\(\\)\\-]+\\s*,)*\\s*[a-z0-9\\-_]+\\s+[ a-z0-9_\\(\\)\\-]+\\)\\s*(--|;)",
-	";\\s*create\\s+table\\s+[ a-z0-9\\-_\\(\\)]+\\s*as\\s+select[ a-z0-9'\"\\*,_\\(\\)\\-]+from[ a-z0-9\\-_\\(\\)]+.*(--|;)",
-	";\\s*create\\s+(recursive|temporary)?\\s*view\\s+[ a-z0-9\\-_\\(\\)]+.*\\s+as\\s+select[ a-z0-9'\"\\*,_\\(\\)\\-]+from[ a-z0-9\\-_\\(\\)]+.*(--|;)",
-	";\\s*create(\\s+unique)?\\s+index\\s+[ a-z0-9\\-_\\(\\)]+\\s+on.*(--|;)"}
+// appliesTo() checks if the rule targets the given input (see targets.go)
+func (rule *Rule) appliesTo(in *input) bool {
+	if len(rule.targets) == 0 {
+		return !in.isName && !in.explicit
+	}
+	applies := false
+	for _, target := range rule.targets {
+		if !target.matches(in) {
+			continue
+		}
+		if target.exclude {
+			return false
+		}
+		applies = true
+	}
+	return applies
+}
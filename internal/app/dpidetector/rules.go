@@ -0,0 +1,179 @@
+package dpidetector
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+/*
+This file loads the rules of the Detector from rule files. A rule file is a YAML document with a list of rules:
+
+	rules:
+	  - id: 1001                      # unique positive number
+	    msg: 'Path traversal'         # message reported with a match
+	    category: path_traversal      # category for the enforcement configuration
+	    severity: critical            # critical, error, warning or notice
+	    references: [https://...]     # optional
+	    type: literal                 # literal, regex, phrases or equals
+	    pattern: '../'
+	    targets: [url, !args:q]       # optional, see targets.go
+	    view: normalized              # normalized (default) or raw, the view of the fields the transforms are applied to
+	    transforms: [urlDecode]       # optional, applied in the given order (see dpipreprocessor/transforms.go)
+	    action: block                 # block (default) or pass
+	    score: 5                      # optional anomaly score, the score of the severity if omitted
+*/
+
+// ruleFile represents the structure of a rule file. The rules are kept as YAML nodes to report the line of an invalid rule.
+type ruleFile struct {
+	Rules []yamlv3.Node `yaml:"rules"`
+}
+
+// ruleEntry represents a single rule as it is written in a rule file
+type ruleEntry struct {
+	ID         int      `yaml:"id"`
+	Msg        string   `yaml:"msg"`
+	Category   string   `yaml:"category"`
+	Severity   string   `yaml:"severity"`
+	References []string `yaml:"references"`
+	Type       string   `yaml:"type"`
+	Pattern    string   `yaml:"pattern"`
+	Targets    []string `yaml:"targets"`
+	View       string   `yaml:"view"`
+	Transforms []string `yaml:"transforms"`
+	Action     string   `yaml:"action"`
+	Score      int      `yaml:"score"`
+}
+
+// ruleLocation is a loaded rule together with the line of its definition in the rule file
+type ruleLocation struct {
+	rule *Rule
+	line int
+}
+
+/*
+This function loads and compiles all rules of the given rule files. Files with the extension ".rules" are imported
+as Snort or Suricata rules (see snort.go), files with the extension ".conf" as ModSecurity rules (see secrule.go) and
+all other files are loaded as YAML rule files. SecRuleRemoveById directives remove the rules of all files loaded before.
+
+@param paths: Paths of the rule files
+
+@return rules: All rules of the rule files in the order of their definition
+@return skipped: Imported rules, which could not be converted into detector rules
+*/
+func LoadRuleFiles(paths []string) (rules []*Rule, skipped []SkippedRule, err error) {
+	ids := make(map[int]string)
+	for _, path := range paths {
+		var loaded []ruleLocation
+		var skippedInFile []SkippedRule
+		var removed []idRange
+		imported := true
+		switch filepath.Ext(path) {
+		case ".rules":
+			loaded, skippedInFile, err = importSnortRules(path)
+		case ".conf":
+			loaded, skippedInFile, removed, err = importSecRules(path)
+		default:
+			imported = false
+			loaded, err = loadYamlRules(path)
+		}
+		skipped = append(skipped, skippedInFile...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dpidetector: LoadRuleFiles(): %w", err)
+		}
+
+		for _, location := range loaded {
+			if previous, ok := ids[location.rule.ID]; ok {
+				reason := fmt.Sprintf("rule %d: id is already used in %s", location.rule.ID, previous)
+				// A rule set of a third party must not prevent the start because of a single rule
+				if imported {
+					skipped = append(skipped, SkippedRule{Path: path, Line: location.line, Reason: reason})
+					continue
+				}
+				return nil, nil, fmt.Errorf("dpidetector: LoadRuleFiles(): %s:%d: %s", path, location.line, reason)
+			}
+			ids[location.rule.ID] = fmt.Sprintf("%s:%d", path, location.line)
+			rules = append(rules, location.rule)
+		}
+
+		if len(removed) > 0 {
+			rules = removeRules(rules, removed, ids)
+		}
+	}
+	return rules, skipped, nil
+}
+
+// removeRules() removes all rules with an ID in one of the ranges. The IDs of the removed rules are released, so that
+// the rules of the following files can use them again.
+func removeRules(rules []*Rule, removed []idRange, ids map[int]string) (remaining []*Rule) {
+	for _, rule := range rules {
+		keep := true
+		for _, r := range removed {
+			if r.contains(rule.ID) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			remaining = append(remaining, rule)
+		} else {
+			delete(ids, rule.ID)
+		}
+	}
+	return remaining
+}
+
+// loadYamlRules() loads and compiles all rules of a YAML rule file
+func loadYamlRules(path string) (rules []ruleLocation, err error) {
+	var file ruleFile
+	err = yaml.LoadYamlFile(path, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range file.Rules {
+		node := &file.Rules[i]
+		rule, err := decodeRule(node)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, node.Line, err)
+		}
+		rules = append(rules, ruleLocation{rule: rule, line: node.Line})
+	}
+	return rules, nil
+}
+
+// decodeRule() converts a YAML node of a rule file into a compiled rule
+func decodeRule(node *yamlv3.Node) (*Rule, error) {
+	var entry ruleEntry
+	err := node.Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+
+	severity, err := ParseSeverity(entry.Severity)
+	if err != nil {
+		return nil, fmt.Errorf("rule %d: %w", entry.ID, err)
+	}
+
+	rule := &Rule{
+		ID:         entry.ID,
+		Msg:        entry.Msg,
+		Category:   entry.Category,
+		Severity:   severity,
+		References: entry.References,
+		Type:       entry.Type,
+		Pattern:    entry.Pattern,
+		Targets:    entry.Targets,
+		View:       entry.View,
+		Transforms: entry.Transforms,
+		Action:     entry.Action,
+		Score:      entry.Score,
+	}
+	err = rule.compile()
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
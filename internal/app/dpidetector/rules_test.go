@@ -0,0 +1,97 @@
+package dpidetector
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testYamlRule = `rules:
+  - id: 1000001
+    msg: 'Test'
+    category: path_traversal
+    severity: critical
+    type: literal
+    pattern: '../'
+    targets: [url, args]
+    transforms: [urlDecode]
+`
+
+func TestLoadYamlRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{"valid rule", testYamlRule, ""},
+		{"unknown severity", strings.Replace(testYamlRule, "critical", "high", 1), "rule 1000001: unknown severity 'high'"},
+		{"unknown type", strings.Replace(testYamlRule, "literal", "glob", 1), "rule 1000001: unknown type 'glob'"},
+		{"unknown target", strings.Replace(testYamlRule, "[url, args]", "[uri]", 1), "rule 1000001: unknown target 'uri'"},
+		{"exclusion without name", strings.Replace(testYamlRule, "[url, args]", "[url, '!args']", 1),
+			"rule 1000001: exclusion '!args' does not name a field"},
+		{"unknown transform", strings.Replace(testYamlRule, "urlDecode", "base64Decode", 1),
+			"rule 1000001: unknown transform 'base64Decode'"},
+		{"invalid regex", strings.Replace(strings.Replace(testYamlRule, "literal", "regex", 1), "../", "([", 1),
+			"rule 1000001: error parsing regexp"},
+		{"missing pattern", strings.Replace(testYamlRule, "    pattern: '../'\n", "", 1), "rule 1000001: no pattern defined"},
+		{"invalid id", strings.Replace(testYamlRule, "1000001", "-1", 1), "rule id must be a positive number"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeRuleFile(t, "test.yml", test.rules)
+			rules, err := loadYamlRules(path)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != 1 || rules[0].line != 2 {
+				t.Errorf("rules = %+v, want one rule in line 2", rules)
+			}
+		})
+	}
+}
+
+func TestLoadRuleFilesDuplicateIDs(t *testing.T) {
+	yamlRules := writeRuleFile(t, "test.yml", testYamlRule)
+	snortRule := writeRuleFile(t, "test.rules", `alert http any any -> any any (content:"a"; sid:1000001;)`)
+	removal := writeRuleFile(t, "remove.conf", "SecRuleRemoveById 1000000-1000009\n")
+
+	// A duplicate ID of a YAML rule prevents the start
+	_, _, err := LoadRuleFiles([]string{yamlRules, writeRuleFile(t, "other.yml", testYamlRule)})
+	if err == nil || !strings.Contains(err.Error(), "id is already used") {
+		t.Errorf("error = %v, want a duplicate id", err)
+	}
+
+	// A duplicate ID of an imported rule is skipped
+	rules, skipped, err := LoadRuleFiles([]string{yamlRules, snortRule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Type != RuleTypeLiteral || rules[0].Pattern != "../" || len(skipped) != 1 {
+		t.Errorf("rules = %d, skipped = %+v, want the YAML rule and the Snort rule skipped", len(rules), skipped)
+	}
+
+	// The ID of a removed rule can be used again by the following files
+	rules, skipped, err = LoadRuleFiles([]string{yamlRules, removal, snortRule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Pattern != "a" || len(skipped) != 0 {
+		t.Errorf("rules = %d, skipped = %+v, want only the Snort rule", len(rules), skipped)
+	}
+}
+
+func TestLoadRuleFilesExamples(t *testing.T) {
+	paths, err := filepath.Glob("../../../rules/*")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no rule files found: %v", err)
+	}
+	if _, skipped, err := LoadRuleFiles(paths); err != nil || len(skipped) != 0 {
+		t.Errorf("error = %v, skipped = %+v", err, skipped)
+	}
+}
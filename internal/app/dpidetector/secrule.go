@@ -0,0 +1,558 @@
+package dpidetector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file parses rule files in the ModSecurity rule language, which allows to run a part of the OWASP Core Rule Set
+(CRS) and existing CRS tuning in the Detector. The following subset is supported:
+
+	Directives: SecRule (including chained rules), SecRuleRemoveById; SecMarker is ignored
+	Variables:  ARGS, ARGS_GET, ARGS_POST, ARGS_NAMES, ARGS_GET_NAMES, REQUEST_HEADERS, REQUEST_HEADERS_NAMES,
+	            REQUEST_COOKIES, REQUEST_COOKIES_NAMES, REQUEST_URI, REQUEST_URI_RAW, REQUEST_FILENAME, QUERY_STRING,
+	            REQUEST_BODY, FILES, XML,
+	            including selectors (REQUEST_HEADERS:User-Agent, ARGS:/^id_/) and exclusions (!ARGS:password)
+	Operators:  @rx (default), @pm, @contains, @streq
+	Actions:    id, msg, severity, block, deny, drop, pass, setvar (anomaly scores), t:, chain and the actions without effect on
+	            the matching (phase, rev, ver, tag, logdata, capture, maturity, accuracy, log, nolog, auditlog,
+	            noauditlog, multiMatch, status)
+
+Variables, which are not supported, are removed from a rule. Rules without any supported variable, with unsupported
+operators, transforms or actions, and other directives are skipped and reported. Like in ModSecurity, @pm matches
+case insensitively, while all other operators match case sensitively unless the rule uses t:lowercase or (?i).
+REQUEST_URI and QUERY_STRING are matched against the path with the query and the query as a whole, so patterns can
+span several arguments.
+*/
+
+// Targets of the supported ModSecurity variables
+var secRuleVariables = map[string][]string{
+	"ARGS":                  {TargetArgs},
+	"ARGS_GET":              {TargetQuery},
+	"ARGS_POST":             {TargetForm, TargetJSON},
+	"ARGS_NAMES":            {TargetArgsNames},
+	"ARGS_GET_NAMES":        {TargetArgsNames},
+	"REQUEST_HEADERS":       {TargetHeaders},
+	"REQUEST_HEADERS_NAMES": {TargetHeaderNames},
+	"REQUEST_COOKIES":       {TargetCookies},
+	"REQUEST_COOKIES_NAMES": {TargetCookieNames},
+	"REQUEST_URI":           {TargetRequestURI},
+	"REQUEST_URI_RAW":       {TargetRequestURI},
+	"REQUEST_FILENAME":      {TargetPath},
+	"QUERY_STRING":          {TargetQueryString},
+	"REQUEST_BODY":          {TargetBody},
+	"FILES":                 {TargetFiles},
+	"XML":                   {TargetXML},
+}
+
+// Variables, which contain the fields as received instead of their normalized form
+var secRuleRawVariables = map[string]bool{
+	"REQUEST_URI_RAW": true,
+}
+
+// Rule types of the supported ModSecurity operators
+var secRuleOperators = map[string]string{
+	"rx":       RuleTypeRegex,
+	"pm":       RuleTypePhrases,
+	"contains": RuleTypeLiteral,
+	"streq":    RuleTypeEquals,
+}
+
+// Detector transforms of the supported ModSecurity transforms. "none" resets the list of transforms.
+var secRuleTransforms = map[string][]string{
+	"lowercase":          {"lowercase"},
+	"urlDecode":          {"urlDecode"},
+	"urlDecodeUni":       {"urlDecode", "unicodeDecode"},
+	"utf8toUnicode":      {"unicodeDecode"},
+	"htmlEntityDecode":   {"htmlEntityDecode"},
+	"removeNulls":        {"removeNulls"},
+	"compressWhitespace": {"compressWhitespace"},
+	"removeComments":     {"removeComments"},
+	"replaceComments":    {"replaceComments"},
+	"normalizePath":      {"normalizePath"},
+	"normalisePath":      {"normalizePath"},
+	"normalizePathWin":   {"normalizePath"},
+	"normalisePathWin":   {"normalizePath"},
+}
+
+// Actions, which do not influence the matching of a rule
+var secRuleIgnoredActions = map[string]bool{
+	"phase":      true,
+	"rev":        true,
+	"ver":        true,
+	"tag":        true,
+	"logdata":    true,
+	"capture":    true,
+	"maturity":   true,
+	"accuracy":   true,
+	"log":        true,
+	"nolog":      true,
+	"auditlog":   true,
+	"noauditlog": true,
+	"multiMatch": true,
+	"status":     true,
+}
+
+// Categories of the attack tags of the CRS
+var secRuleTagCategories = map[string]string{
+	"attack-sqli": CategorySQLInjection,
+	"attack-lfi":  CategoryPathTraversal,
+	"attack-rfi":  CategoryFileInclusion,
+	"attack-xss":  CategoryXSS,
+	"attack-rce":  CategoryCommandInjection,
+	"attack-ssrf": CategorySSRF,
+}
+
+// idRange is an inclusive range of rule IDs of a SecRuleRemoveById directive
+type idRange struct {
+	from, to int
+}
+
+func (r idRange) contains(id int) bool {
+	return id >= r.from && id <= r.to
+}
+
+// secRuleParser keeps the state of a rule file, which spans several directives
+type secRuleParser struct {
+	path    string
+	rules   []ruleLocation
+	skipped []SkippedRule
+	removed []idRange
+
+	// Last rule of an unfinished chain and the line of its head
+	chainTail *Rule
+	chainHead ruleLocation
+	// A chain, which contains an unsupported rule, is skipped completely
+	chainSkipped bool
+}
+
+/*
+This function parses all rules of a ModSecurity rule file.
+
+@param path: Path of the rule file
+
+@return rules: Converted rules with the line of their definition
+@return skipped: Rules and directives, which could not be converted, with the reason
+@return removed: ID ranges of SecRuleRemoveById directives
+*/
+func importSecRules(path string) (rules []ruleLocation, skipped []SkippedRule, removed []idRange, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("could not open rule file: %w", err)
+	}
+	defer file.Close()
+
+	parser := &secRuleParser{path: path}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	lineNumber, startLine := 0, 0
+	var text string
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			startLine = lineNumber
+		}
+
+		// A backslash at the end of a line continues the directive in the next line
+		if strings.HasSuffix(line, "\\") {
+			text += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		text += line
+
+		parser.parseDirective(text, startLine)
+		text = ""
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, nil, fmt.Errorf("could not read rule file: %w", err)
+	}
+	if parser.chainTail != nil || parser.chainSkipped {
+		parser.skip(parser.chainHead.line, "chain is not terminated")
+	}
+	return parser.rules, parser.skipped, parser.removed, nil
+}
+
+func (parser *secRuleParser) skip(line int, reason string) {
+	parser.skipped = append(parser.skipped, SkippedRule{Path: parser.path, Line: line, Reason: reason})
+}
+
+// parseDirective() processes a single directive of the rule file
+func (parser *secRuleParser) parseDirective(text string, line int) {
+	args, err := splitSecRuleArguments(text)
+	if err != nil {
+		parser.skip(line, err.Error())
+		return
+	}
+
+	switch args[0] {
+	case "SecRule":
+		parser.parseSecRule(args[1:], line)
+	case "SecRuleRemoveById":
+		for _, arg := range args[1:] {
+			r, err := parseIDRange(arg)
+			if err != nil {
+				parser.skip(line, err.Error())
+				continue
+			}
+			parser.removed = append(parser.removed, r)
+		}
+	case "SecMarker":
+	default:
+		parser.skip(line, fmt.Sprintf("unsupported directive '%s'", args[0]))
+	}
+}
+
+// parseSecRule() converts a SecRule directive into a rule or appends it to the current chain
+func (parser *secRuleParser) parseSecRule(args []string, line int) {
+	inChain := parser.chainTail != nil || parser.chainSkipped
+	id := 0
+	if inChain {
+		// Chained rules have no id of their own
+		id = parser.chainHead.rule.ID
+	}
+	rule, chain, err := parseSecRuleArguments(args, id)
+
+	switch {
+	case inChain && parser.chainSkipped:
+	case err != nil && inChain:
+		parser.skip(parser.chainHead.line, fmt.Sprintf("chained rule in line %d: %v", line, err))
+		parser.chainSkipped = true
+	case err != nil:
+		parser.skip(line, err.Error())
+		parser.chainSkipped = chain
+		parser.chainHead = ruleLocation{rule: &Rule{}, line: line}
+	case inChain:
+		parser.chainTail.Chain = rule
+		parser.chainTail = rule
+	default:
+		parser.chainHead = ruleLocation{rule: rule, line: line}
+		parser.chainTail = rule
+	}
+
+	if chain {
+		return
+	}
+
+	// The chain is complete
+	if parser.chainTail != nil && !parser.chainSkipped {
+		head := parser.chainHead.rule
+		if err := head.compile(); err != nil {
+			parser.skip(parser.chainHead.line, err.Error())
+		} else {
+			parser.rules = append(parser.rules, parser.chainHead)
+		}
+	}
+	parser.chainTail, parser.chainSkipped = nil, false
+}
+
+/*
+This function converts the arguments of a SecRule directive into an uncompiled rule.
+
+@param args: Variables, operator and the optional actions of the directive
+@param id: ID of the rule, if it is not defined by the actions
+
+@return rule: Converted rule
+@return chain: True, when the rule is continued by the next SecRule directive
+*/
+func parseSecRuleArguments(args []string, id int) (rule *Rule, chain bool, err error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, false, fmt.Errorf("SecRule expects variables, operator and actions")
+	}
+
+	rule = &Rule{ID: id, Action: ActionBlock, Severity: SeverityCritical}
+	if len(args) == 3 {
+		chain, err = parseSecRuleActions(rule, args[2])
+		if err != nil {
+			return nil, chain, err
+		}
+	}
+
+	rule.Targets, rule.View, err = parseSecRuleVariables(args[0])
+	if err != nil {
+		return nil, chain, fmt.Errorf("rule %d: %w", rule.ID, err)
+	}
+
+	operator, value := "rx", args[1]
+	if strings.HasPrefix(value, "!") {
+		return nil, chain, fmt.Errorf("rule %d: negated operators are not supported", rule.ID)
+	}
+	if strings.HasPrefix(value, "@") {
+		operator, value = value[1:], ""
+		if space := strings.IndexAny(operator, " \t"); space >= 0 {
+			operator, value = operator[:space], strings.TrimSpace(operator[space+1:])
+		}
+	}
+	ruleType, ok := secRuleOperators[operator]
+	if !ok {
+		return nil, chain, fmt.Errorf("rule %d: unsupported operator '@%s'", rule.ID, operator)
+	}
+	rule.Type, rule.Pattern = ruleType, value
+	if operator == "pm" {
+		rule.Pattern = dpipreprocessor.LowercaseASCII(value)
+		if n := len(rule.Transforms); n == 0 || rule.Transforms[n-1] != "lowercaseASCII" {
+			rule.Transforms = append(rule.Transforms, "lowercaseASCII")
+		}
+	}
+
+	return rule, chain, nil
+}
+
+// parseSecRuleVariables() maps the variables of a rule to the targets and the view of the Detector
+func parseSecRuleVariables(text string) (targets []string, view string, err error) {
+	seen := make(map[string]bool)
+	included := false
+	view = dpipreprocessor.ViewNormalized
+	for _, variable := range strings.Split(text, "|") {
+		// Counts of collections are not supported
+		if strings.HasPrefix(variable, "&") {
+			continue
+		}
+		exclude := strings.HasPrefix(variable, "!")
+		name, selector := strings.TrimPrefix(variable, "!"), ""
+		if colon := strings.Index(name, ":"); colon >= 0 {
+			name, selector = name[:colon], strings.Trim(name[colon+1:], "'")
+		}
+		if exclude && selector == "" {
+			continue
+		}
+
+		variableView := dpipreprocessor.ViewNormalized
+		if secRuleRawVariables[strings.ToUpper(name)] {
+			variableView = dpipreprocessor.ViewRaw
+		}
+		for _, target := range secRuleVariables[strings.ToUpper(name)] {
+			if included && !exclude && variableView != view {
+				return nil, "", fmt.Errorf("raw and normalized variables cannot be combined in '%s'", text)
+			}
+			if selector != "" {
+				target += ":" + selector
+			}
+			if exclude {
+				target = "!" + target
+			} else {
+				included, view = true, variableView
+			}
+			if !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	if !included {
+		return nil, "", fmt.Errorf("no supported variable in '%s'", text)
+	}
+	return targets, view, nil
+}
+
+// parseSecRuleActions() applies the comma separated actions to the rule
+func parseSecRuleActions(rule *Rule, text string) (chain bool, err error) {
+	actions := splitSecRuleActions(text)
+
+	// The chain is needed first to skip the chained rules of an unsupported rule (CRS puts it last) and the id to report
+	// problems with the other actions
+	for _, action := range actions {
+		chain = chain || action.name == "chain"
+	}
+	for _, action := range actions {
+		if action.name == "id" {
+			rule.ID, err = strconv.Atoi(action.value)
+			if err != nil {
+				return chain, fmt.Errorf("invalid id '%s'", action.value)
+			}
+		}
+	}
+
+	for _, action := range actions {
+		switch action.name {
+		case "id":
+		case "msg":
+			rule.Msg = action.value
+		case "severity":
+			rule.Severity, err = parseSecRuleSeverity(action.value)
+			if err != nil {
+				return chain, fmt.Errorf("rule %d: %w", rule.ID, err)
+			}
+		case "block", "deny", "drop":
+			rule.Action = ActionBlock
+		case "pass":
+			rule.Action = ActionPass
+		case "chain":
+		case "t":
+			if action.value == "none" {
+				rule.Transforms = nil
+				continue
+			}
+			transforms, ok := secRuleTransforms[action.value]
+			if !ok {
+				return chain, fmt.Errorf("rule %d: unsupported transform '%s'", rule.ID, action.value)
+			}
+			rule.Transforms = append(rule.Transforms, transforms...)
+		case "setvar":
+			rule.Score, err = parseSecRuleScore(action.value, rule.Score)
+			if err != nil {
+				return chain, fmt.Errorf("rule %d: %w", rule.ID, err)
+			}
+		case "tag":
+			if category, ok := secRuleTagCategories[action.value]; ok {
+				rule.Category = category
+			}
+		default:
+			if !secRuleIgnoredActions[action.name] {
+				return chain, fmt.Errorf("rule %d: unsupported action '%s'", rule.ID, action.name)
+			}
+		}
+	}
+
+	if rule.Category == "" {
+		rule.Category = "modsecurity"
+	}
+	return chain, nil
+}
+
+/*
+This function evaluates a setvar action. Only increments of an anomaly score are supported, all other variables have
+no effect. An increment by a severity score (e.g. "tx.anomaly_score_pl1=+%{tx.critical_anomaly_score}") corresponds to
+the configured score of the severity, so only an increment by a number sets the score of the rule.
+
+@param value: Value of the setvar action
+@param score: Score of the rule before the action
+
+@return score: Score of the rule after the action
+*/
+func parseSecRuleScore(value string, score int) (int, error) {
+	assignment := strings.SplitN(value, "=", 2)
+	if len(assignment) != 2 || !strings.Contains(strings.ToLower(assignment[0]), "anomaly_score") {
+		return score, nil
+	}
+	increment := assignment[1]
+	if !strings.HasPrefix(increment, "+") || strings.HasPrefix(increment, "+%{") {
+		return score, nil
+	}
+	points, err := strconv.Atoi(increment[1:])
+	if err != nil || points < 0 {
+		return score, fmt.Errorf("invalid anomaly score increment '%s'", increment)
+	}
+	return score + points, nil
+}
+
+// parseSecRuleSeverity() converts a ModSecurity severity (name or syslog level) into a severity
+func parseSecRuleSeverity(value string) (Severity, error) {
+	switch strings.ToUpper(value) {
+	case "0", "1", "2", "EMERGENCY", "ALERT", "CRITICAL":
+		return SeverityCritical, nil
+	case "3", "ERROR":
+		return SeverityError, nil
+	case "4", "WARNING":
+		return SeverityWarning, nil
+	case "5", "6", "7", "NOTICE", "INFO", "DEBUG":
+		return SeverityNotice, nil
+	}
+	return 0, fmt.Errorf("unknown severity '%s'", value)
+}
+
+type secRuleAction struct {
+	name  string
+	value string
+}
+
+// splitSecRuleActions() splits the action list at commas, which are not part of a single quoted value
+func splitSecRuleActions(text string) (actions []secRuleAction) {
+	var current strings.Builder
+	quoted, escaped := false, false
+	flush := func() {
+		action := strings.TrimSpace(current.String())
+		current.Reset()
+		if action == "" {
+			return
+		}
+		name, value := action, ""
+		if colon := strings.Index(action, ":"); colon >= 0 {
+			name, value = strings.TrimSpace(action[:colon]), strings.TrimSpace(action[colon+1:])
+		}
+		actions = append(actions, secRuleAction{name: name, value: strings.Trim(value, "'")})
+	}
+
+	for i := 0; i < len(text); i++ {
+		r := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '\'':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			flush()
+			continue
+		}
+		current.WriteByte(r)
+	}
+	flush()
+	return actions
+}
+
+// splitSecRuleArguments() splits a directive at whitespace, which is not part of a double quoted argument
+func splitSecRuleArguments(text string) (args []string, err error) {
+	var current strings.Builder
+	quoted, escaped, inArg := false, false, false
+	// The text is split bytewise to keep invalid UTF-8 bytes of the patterns
+	for i := 0; i < len(text); i++ {
+		r := text[i]
+		switch {
+		case escaped:
+			// Only escaped quotes are unescaped, all other escapes belong to the argument (e.g. a regex)
+			if r != '"' {
+				current.WriteByte('\\')
+			}
+			current.WriteByte(r)
+			escaped = false
+		case r == '\\':
+			escaped, inArg = true, true
+		case r == '"':
+			quoted, inArg = !quoted, true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteByte(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quoted argument")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// parseIDRange() parses a single ID or an ID range ("942100-942999") of a SecRuleRemoveById directive
+func parseIDRange(text string) (idRange, error) {
+	parts := strings.SplitN(text, "-", 2)
+	from, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return idRange{}, fmt.Errorf("invalid rule id '%s'", text)
+	}
+	to := from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(parts[1])
+		if err != nil || to < from {
+			return idRange{}, fmt.Errorf("invalid rule id range '%s'", text)
+		}
+	}
+	return idRange{from: from, to: to}, nil
+}
@@ -0,0 +1,148 @@
+package dpidetector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+func TestParseSecRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want *Rule // Only the compared attributes are set
+		err  string
+	}{
+		{"regular expression",
+			`SecRule ARGS|!ARGS:password "@rx (?i)union\s+select" "id:1,phase:2,block,msg:'SQLi',severity:CRITICAL,` +
+				`tag:'attack-sqli',t:none,t:urlDecodeUni,t:lowercase"`,
+			&Rule{ID: 1, Msg: "SQLi", Category: CategorySQLInjection, Severity: SeverityCritical, Type: RuleTypeRegex,
+				Pattern: `(?i)union\s+select`, Targets: []string{TargetArgs, "!" + TargetArgs + ":password"},
+				View: dpipreprocessor.ViewNormalized, Transforms: []string{"urlDecode", "unicodeDecode", "lowercase"},
+				Action: ActionBlock}, ""},
+		{"phrase match",
+			`SecRule REQUEST_HEADERS:User-Agent "@pm Nikto SQLMap" "id:2,pass,severity:WARNING"`,
+			&Rule{ID: 2, Category: "modsecurity", Severity: SeverityWarning, Type: RuleTypePhrases,
+				Pattern: "nikto sqlmap", Targets: []string{TargetHeaders + ":User-Agent"},
+				View: dpipreprocessor.ViewNormalized, Transforms: []string{"lowercaseASCII"}, Action: ActionPass}, ""},
+		{"raw variable",
+			`SecRule REQUEST_URI_RAW "@contains %00" "id:3,deny"`,
+			&Rule{ID: 3, Category: "modsecurity", Severity: SeverityCritical, Type: RuleTypeLiteral, Pattern: "%00",
+				Targets: []string{TargetRequestURI}, View: dpipreprocessor.ViewRaw, Action: ActionBlock}, ""},
+		{"unsupported operator", `SecRule ARGS "@detectSQLi" "id:4,block"`, nil,
+			"rule 4: unsupported operator '@detectSQLi'"},
+		{"negated operator", `SecRule ARGS "!@rx a" "id:5,block"`, nil, "rule 5: negated operators are not supported"},
+		{"no supported variable", `SecRule TX:score "@rx a" "id:6,block"`, nil, "no supported variable in 'TX:score'"},
+		{"raw and normalized variables", `SecRule REQUEST_URI_RAW|ARGS "@rx a" "id:7,block"`, nil,
+			"raw and normalized variables cannot be combined in 'REQUEST_URI_RAW|ARGS'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args, err := splitSecRuleArguments(test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule, _, err := parseSecRuleArguments(args[1:], 0)
+			if err == nil {
+				err = rule.compile()
+			}
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &Rule{ID: rule.ID, Msg: rule.Msg, Category: rule.Category, Severity: rule.Severity, Type: rule.Type,
+				Pattern: rule.Pattern, Targets: rule.Targets, View: rule.View, Transforms: rule.Transforms,
+				Action: rule.Action}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("rule = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// REQUEST_URI and QUERY_STRING contain the whole query instead of the single arguments
+func TestSecRuleRequestURI(t *testing.T) {
+	rules := strings.Join([]string{
+		`SecRule REQUEST_URI "@contains /login.php?user=" "id:1000001,block"`,
+		`SecRule REQUEST_URI_RAW "@contains ?a=%27" "id:1000002,block"`,
+		`SecRule QUERY_STRING "@rx a=1&b=2$" "id:1000003,block"`,
+		`SecRule ARGS_GET "@streq 1" "id:1000004,pass"`,
+	}, "\n")
+	detector := newTestDetector(t, "test.conf", rules, config.ServiceFunctionT{})
+
+	tests := []struct {
+		name   string
+		target string
+		want   []int
+	}{
+		{"path with query", "/login.php?user=admin", []int{1000001}},
+		{"raw query", "/?a=%27", []int{1000002}},
+		{"decoded query", "/?a='", nil},
+		{"whole query", "/?a=1&b=2", []int{1000003, 1000004}},
+		{"single argument", "/?b=2&a=1", []int{1000004}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ids := detect(t, detector, testRequest{target: test.target}); !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+// @pm matches case insensitively like ModSecurity, which converts only ASCII letters
+func TestSecRulePhraseMatch(t *testing.T) {
+	detector := newTestDetector(t, "test.conf",
+		"SecRule ARGS \"@pm \xC0\xAFETC STRAßE\" \"id:1000001,block,severity:CRITICAL,tag:'attack-lfi'\"",
+		config.ServiceFunctionT{})
+
+	tests := []struct {
+		name   string
+		target string
+		want   []int
+	}{
+		{"overlong slash", "/?f=%C0%AFEtc", []int{1000001}},
+		{"non-ascii kept", "/?s=Stra%C3%9Fe", []int{1000001}},
+		{"non-ascii not folded", "/?s=STRASSE", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ids := detect(t, detector, testRequest{target: test.target}); !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+// A rule with unsupported actions is skipped together with its chained rules, even if "chain" is its last action
+func TestImportSecRulesSkippedChain(t *testing.T) {
+	path := writeRuleFile(t, "test.conf", strings.Join([]string{
+		`SecRule ARGS "@rx a" "id:1000001,t:bogusTransform,block,chain"`,
+		`    SecRule ARGS "@rx b"`,
+		`SecRule ARGS "@rx c" "id:1000002,severity:BOGUS,chain"`,
+		`    SecRule ARGS "@rx d"`,
+		`SecRule ARGS "@rx e" "id:1000003,block"`,
+	}, "\n"))
+	rules, skipped, _, err := importSecRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].rule.ID != 1000003 {
+		t.Errorf("rules = %+v, want only rule 1000003", rules)
+	}
+	want := []SkippedRule{
+		{path, 1, "rule 1000001: unsupported transform 'bogusTransform'"},
+		{path, 3, "rule 1000002: unknown severity 'BOGUS'"},
+	}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %+v, want %+v", skipped, want)
+	}
+}
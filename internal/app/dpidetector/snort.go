@@ -0,0 +1,495 @@
+package dpidetector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file imports the HTTP relevant subset of the Snort 3 and Suricata rule language. A rule is converted into a
+detector rule, if all of its options are supported:
+
+	content (with nocase), pcre, http_uri, http_raw_uri, http_header, http_raw_header, http_client_body, http_cookie
+	(as content modifiers or as sticky buffers), msg, sid, classtype, priority, reference and the options without
+	effect on the matching (rev, gid, metadata, flow, fast_pattern, service)
+
+A rule with several content or pcre options is converted into a chain of conditions, which all have to match.
+Rules with unsupported options (e.g. positional modifiers like offset or distance) are skipped and reported.
+Like in Snort, contents are matched case sensitively unless they are followed by nocase, and the raw buffers are
+matched against the raw view of the fields. The URI buffers contain the path with the query as a single string and
+the header buffers all headers as lines "Name: value", so contents can span several arguments or a header name and
+its value.
+*/
+
+// SkippedRule describes a rule of an imported rule file, which could not be converted into a detector rule
+type SkippedRule struct {
+	Path   string
+	Line   int
+	Reason string
+}
+
+func (skipped SkippedRule) String() string {
+	return fmt.Sprintf("%s:%d: %s", skipped.Path, skipped.Line, skipped.Reason)
+}
+
+// Targets of the HTTP buffers of Snort and Suricata
+var snortBuffers = map[string]string{
+	"http_uri":          TargetRequestURI,
+	"http.uri":          TargetRequestURI,
+	"http_raw_uri":      TargetRequestURI,
+	"http.uri.raw":      TargetRequestURI,
+	"http_header":       TargetHeaderLines,
+	"http.header":       TargetHeaderLines,
+	"http_raw_header":   TargetHeaderLines,
+	"http.header.raw":   TargetHeaderLines,
+	"http_cookie":       TargetCookies,
+	"http.cookie":       TargetCookies,
+	"http_client_body":  TargetBody,
+	"http.request_body": TargetBody,
+}
+
+// Buffers, which contain the fields as received instead of their normalized form
+var snortRawBuffers = map[string]bool{
+	"http_raw_uri":    true,
+	"http.uri.raw":    true,
+	"http_raw_header": true,
+	"http.header.raw": true,
+	"I":               true, // Buffer flags of pcre options
+	"D":               true,
+	"K":               true,
+}
+
+// Targets of the buffer flags of Snort pcre options
+var snortPcreBuffers = map[rune]string{
+	'U': TargetRequestURI,
+	'I': TargetRequestURI,
+	'H': TargetHeaderLines,
+	'D': TargetHeaderLines,
+	'C': TargetCookies,
+	'K': TargetCookies,
+	'P': TargetBody,
+}
+
+// Options, which do not influence the matching of a rule
+var snortIgnoredOptions = map[string]bool{
+	"rev":          true,
+	"gid":          true,
+	"metadata":     true,
+	"flow":         true,
+	"fast_pattern": true,
+	"service":      true,
+}
+
+// Priorities of the classtypes of the default Snort classification.config
+var snortClasstypePriorities = map[string]int{
+	"attempted-admin":            1,
+	"attempted-user":             1,
+	"shellcode-detect":           1,
+	"successful-admin":           1,
+	"successful-user":            1,
+	"trojan-activity":            1,
+	"web-application-attack":     1,
+	"policy-violation":           1,
+	"attempted-dos":              2,
+	"attempted-recon":            2,
+	"bad-unknown":                2,
+	"misc-attack":                2,
+	"suspicious-filename-detect": 2,
+	"web-application-activity":   2,
+	"protocol-command-decode":    3,
+	"misc-activity":              3,
+	"string-detect":              3,
+	"unknown":                    3,
+	"not-suspicious":             3,
+}
+
+const snortDefaultPriority = 2
+
+type snortOption struct {
+	name  string
+	value string
+}
+
+/*
+This function imports all rules of a Snort or Suricata rule file.
+
+@param path: Path of the rule file
+
+@return rules: Converted rules with the line of their definition
+@return skipped: Rules, which could not be converted, with the reason
+*/
+func importSnortRules(path string) (rules []ruleLocation, skipped []SkippedRule, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open rule file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	lineNumber, startLine := 0, 0
+	var text string
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			startLine = lineNumber
+		}
+
+		// A backslash at the end of a line continues the rule in the next line
+		if strings.HasSuffix(line, "\\") {
+			text += strings.TrimSuffix(line, "\\")
+			continue
+		}
+		text += line
+
+		rule, err := parseSnortRule(text)
+		if err != nil {
+			skipped = append(skipped, SkippedRule{Path: path, Line: startLine, Reason: err.Error()})
+		} else {
+			rules = append(rules, ruleLocation{rule: rule, line: startLine})
+		}
+		text = ""
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("could not read rule file: %w", err)
+	}
+	return rules, skipped, nil
+}
+
+// parseSnortRule() converts the text of a single Snort or Suricata rule into a detector rule
+func parseSnortRule(text string) (*Rule, error) {
+	open := strings.Index(text, "(")
+	if open < 0 || !strings.HasSuffix(text, ")") {
+		return nil, fmt.Errorf("rule has no option list")
+	}
+
+	header := strings.Fields(text[:open])
+	if len(header) < 2 {
+		return nil, fmt.Errorf("rule header is incomplete")
+	}
+	switch header[0] {
+	case "alert", "drop", "reject", "block", "sdrop":
+	default:
+		return nil, fmt.Errorf("unsupported rule action '%s'", header[0])
+	}
+	if header[1] != "http" && header[1] != "tcp" {
+		return nil, fmt.Errorf("unsupported protocol '%s'", header[1])
+	}
+
+	options, err := splitSnortOptions(text[open+1 : len(text)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	// The sid is needed first to report problems with the other options
+	rule := &Rule{Action: ActionBlock}
+	for _, option := range options {
+		if option.name == "sid" {
+			rule.ID, err = strconv.Atoi(option.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sid '%s'", option.value)
+			}
+		}
+	}
+	if rule.ID == 0 {
+		return nil, fmt.Errorf("rule has no sid")
+	}
+
+	priority := 0
+
+	// Snort 3 and Suricata 5 use sticky buffers, which precede the contents. Older rules use content modifiers.
+	sticky := isSnortStickyStyle(options)
+	buffer, view := "", ""
+	var conditions []*Rule
+	var last *Rule
+
+	for _, option := range options {
+		if target, ok := snortBuffers[option.name]; ok {
+			if sticky {
+				buffer, view = target, snortView(option.name)
+			} else if last == nil || last.Type != RuleTypeLiteral {
+				return nil, fmt.Errorf("rule %d: '%s' does not follow a content", rule.ID, option.name)
+			} else {
+				last.Targets, last.View = []string{target}, snortView(option.name)
+			}
+			continue
+		}
+
+		switch option.name {
+		case "msg":
+			rule.Msg = option.value
+		case "sid":
+		case "classtype":
+			rule.Category = option.value
+		case "priority":
+			priority, err = strconv.Atoi(option.value)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid priority '%s'", rule.ID, option.value)
+			}
+		case "reference":
+			rule.References = append(rule.References, snortReference(option.value))
+		case "content":
+			last, err = parseSnortContent(option.value)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", rule.ID, err)
+			}
+			if buffer != "" {
+				last.Targets, last.View = []string{buffer}, view
+			}
+			conditions = append(conditions, last)
+		case "nocase":
+			if last == nil || last.Type != RuleTypeLiteral {
+				return nil, fmt.Errorf("rule %d: 'nocase' does not follow a content", rule.ID)
+			}
+			last.Pattern, last.Transforms = dpipreprocessor.LowercaseASCII(last.Pattern), []string{"lowercaseASCII"}
+		case "pcre":
+			last, err = parseSnortPcre(option.value)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", rule.ID, err)
+			}
+			if buffer != "" && len(last.Targets) == 0 {
+				last.Targets, last.View = []string{buffer}, view
+			}
+			conditions = append(conditions, last)
+		default:
+			if !snortIgnoredOptions[option.name] {
+				return nil, fmt.Errorf("rule %d: unsupported option '%s'", rule.ID, option.name)
+			}
+		}
+	}
+
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("rule %d: rule has neither content nor pcre", rule.ID)
+	}
+
+	if rule.Category == "" {
+		rule.Category = "snort"
+	}
+	if priority == 0 {
+		priority = snortClasstypePriorities[rule.Category]
+		if priority == 0 {
+			priority = snortDefaultPriority
+		}
+	}
+	rule.Severity = snortSeverity(priority)
+
+	// The first condition is the rule itself, all further conditions are chained to it
+	head := conditions[0]
+	rule.Type, rule.Pattern, rule.Targets = head.Type, head.Pattern, head.Targets
+	rule.View, rule.Transforms = head.View, head.Transforms
+	conditions[0] = rule
+	for i := 1; i < len(conditions); i++ {
+		conditions[i-1].Chain = conditions[i]
+	}
+
+	err = rule.compile()
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// splitSnortOptions() splits the option list of a rule at semicolons, which are not part of a quoted value
+func splitSnortOptions(text string) (options []snortOption, err error) {
+	var current strings.Builder
+	quoted, escaped := false, false
+	for _, r := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ';' && !quoted:
+			if option := strings.TrimSpace(current.String()); option != "" {
+				options = append(options, newSnortOption(option))
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quoted option value")
+	}
+	if option := strings.TrimSpace(current.String()); option != "" {
+		options = append(options, newSnortOption(option))
+	}
+	return options, nil
+}
+
+// newSnortOption() splits an option into its name and its value without quotes. The escaped characters of contents
+// and regular expressions are decoded by their parsers, all other values are unescaped here (e.g. msg:"a\;b").
+func newSnortOption(text string) snortOption {
+	name, value := text, ""
+	if colon := strings.Index(text, ":"); colon >= 0 {
+		name, value = text[:colon], strings.TrimSpace(text[colon+1:])
+	}
+	name = strings.TrimSpace(name)
+	if len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
+		value = value[1 : len(value)-1]
+	}
+	if name != "content" && name != "pcre" {
+		value = unescapeSnortValue(value)
+	}
+	return snortOption{name: name, value: value}
+}
+
+// unescapeSnortValue() replaces escaped characters (\", \;, \\) by the characters themselves
+func unescapeSnortValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var unescaped strings.Builder
+	escaped := false
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		unescaped.WriteByte(value[i])
+		escaped = false
+	}
+	return unescaped.String()
+}
+
+// isSnortStickyStyle() reports if the first HTTP buffer of a rule precedes the first content
+func isSnortStickyStyle(options []snortOption) bool {
+	for _, option := range options {
+		if _, ok := snortBuffers[option.name]; ok {
+			return true
+		}
+		if option.name == "content" || option.name == "pcre" {
+			return false
+		}
+	}
+	return false
+}
+
+// parseSnortContent() decodes escaped characters and hexadecimal bytes (|41 42|) of a content
+func parseSnortContent(value string) (*Rule, error) {
+	if strings.HasPrefix(value, "!") {
+		return nil, fmt.Errorf("negated contents are not supported")
+	}
+
+	var content strings.Builder
+	hex, escaped := false, false
+	hexDigits := ""
+	for _, r := range value {
+		switch {
+		case escaped:
+			content.WriteRune(r)
+			escaped = false
+		case r == '|':
+			if hex && hexDigits != "" {
+				return nil, fmt.Errorf("invalid hexadecimal content '%s'", hexDigits)
+			}
+			hex = !hex
+		case hex:
+			if r == ' ' {
+				continue
+			}
+			hexDigits += string(r)
+			if len(hexDigits) == 2 {
+				b, err := strconv.ParseUint(hexDigits, 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("invalid hexadecimal content '%s'", hexDigits)
+				}
+				content.WriteByte(byte(b))
+				hexDigits = ""
+			}
+		case r == '\\':
+			escaped = true
+		default:
+			content.WriteRune(r)
+		}
+	}
+	if hex {
+		return nil, fmt.Errorf("unterminated hexadecimal content")
+	}
+	if content.Len() == 0 {
+		return nil, fmt.Errorf("empty content")
+	}
+	return &Rule{Type: RuleTypeLiteral, Pattern: content.String()}, nil
+}
+
+// parseSnortPcre() converts a pcre option ("/pattern/flags") into a regex rule
+func parseSnortPcre(value string) (*Rule, error) {
+	if strings.HasPrefix(value, "!") {
+		return nil, fmt.Errorf("negated pcre options are not supported")
+	}
+	end := strings.LastIndex(value, "/")
+	if !strings.HasPrefix(value, "/") || end == 0 {
+		return nil, fmt.Errorf("invalid pcre '%s'", value)
+	}
+
+	rule := &Rule{Type: RuleTypeRegex}
+	flags := ""
+	for _, flag := range value[end+1:] {
+		if target, ok := snortPcreBuffers[flag]; ok {
+			rule.Targets, rule.View = []string{target}, snortView(string(flag))
+			continue
+		}
+		switch flag {
+		case 'i', 's', 'm':
+			flags += string(flag)
+		default:
+			return nil, fmt.Errorf("unsupported pcre flag '%c'", flag)
+		}
+	}
+	rule.Pattern = value[1:end]
+	if flags != "" {
+		rule.Pattern = "(?" + flags + ")" + rule.Pattern
+	}
+	return rule, nil
+}
+
+// snortView() returns the view of the fields, which is inspected by a buffer
+func snortView(buffer string) string {
+	if snortRawBuffers[buffer] {
+		return dpipreprocessor.ViewRaw
+	}
+	return dpipreprocessor.ViewNormalized
+}
+
+// snortReference() converts a reference option ("type,id") into a link, if the type is known
+func snortReference(value string) string {
+	parts := strings.SplitN(value, ",", 2)
+	if len(parts) != 2 {
+		return value
+	}
+	id := strings.TrimSpace(parts[1])
+	switch strings.TrimSpace(parts[0]) {
+	case "url":
+		if strings.Contains(id, "://") {
+			return id
+		}
+		return "http://" + id
+	case "cve":
+		return "https://cve.mitre.org/cgi-bin/cvename.cgi?name=CVE-" + id
+	}
+	return value
+}
+
+// snortSeverity() maps the priority of a rule (1 is the highest priority) to a severity
+func snortSeverity(priority int) Severity {
+	switch priority {
+	case 1:
+		return SeverityCritical
+	case 2:
+		return SeverityError
+	case 3:
+		return SeverityWarning
+	}
+	return SeverityNotice
+}
@@ -0,0 +1,161 @@
+package dpidetector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+func TestParseSnortRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want *Rule // Only the compared attributes are set
+		err  string
+	}{
+		{"content modifier",
+			`alert http any any -> any any (msg:"cmd"; content:"cmd.exe"; nocase; http_uri; classtype:web-application-attack; sid:1;)`,
+			&Rule{ID: 1, Msg: "cmd", Category: "web-application-attack", Severity: SeverityCritical, Type: RuleTypeLiteral,
+				Pattern: "cmd.exe", Targets: []string{TargetRequestURI}, View: dpipreprocessor.ViewNormalized,
+				Transforms: []string{"lowercaseASCII"}}, ""},
+		{"sticky raw buffer",
+			`alert http any any -> any any (http.uri.raw; content:"%2e%2e"; sid:2; priority:3;)`,
+			&Rule{ID: 2, Category: "snort", Severity: SeverityWarning, Type: RuleTypeLiteral, Pattern: "%2e%2e",
+				Targets: []string{TargetRequestURI}, View: dpipreprocessor.ViewRaw}, ""},
+		{"hexadecimal content",
+			`alert tcp any any -> any any (content:"User-Agent|3a 20|sqlmap"; http_header; sid:3;)`,
+			&Rule{ID: 3, Category: "snort", Severity: SeverityError, Type: RuleTypeLiteral, Pattern: "User-Agent: sqlmap",
+				Targets: []string{TargetHeaderLines}, View: dpipreprocessor.ViewNormalized}, ""},
+		{"pcre with buffer flag",
+			`alert http any any -> any any (pcre:"/union\s+select/Ui"; sid:4;)`,
+			&Rule{ID: 4, Category: "snort", Severity: SeverityError, Type: RuleTypeRegex, Pattern: `(?i)union\s+select`,
+				Targets: []string{TargetRequestURI}, View: dpipreprocessor.ViewNormalized}, ""},
+		{"escaped characters",
+			`alert http any any -> any any (msg:"a\;b \"c\" d\\e"; content:"x\;y"; pcre:"/\d+\;/"; sid:10;)`,
+			&Rule{ID: 10, Msg: `a;b "c" d\e`, Category: "snort", Severity: SeverityError, Type: RuleTypeLiteral,
+				Pattern: "x;y", View: dpipreprocessor.ViewNormalized}, ""},
+		{"unsupported option", `alert http any any -> any any (content:"a"; offset:3; sid:5;)`, nil,
+			"rule 5: unsupported option 'offset'"},
+		{"negated content", `alert http any any -> any any (content:!"a"; sid:6;)`, nil,
+			"rule 6: negated contents are not supported"},
+		{"missing sid", `alert http any any -> any any (content:"a";)`, nil, "rule has no sid"},
+		{"unsupported protocol", `alert udp any any -> any any (content:"a"; sid:7;)`, nil, "unsupported protocol 'udp'"},
+		{"unsupported pcre flag", `alert http any any -> any any (pcre:"/a/R"; sid:8;)`, nil, "rule 8: unsupported pcre flag 'R'"},
+		{"modifier without content", `alert http any any -> any any (pcre:"/a/"; http_uri; sid:9;)`, nil,
+			"rule 9: 'http_uri' does not follow a content"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := parseSnortRule(test.rule)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &Rule{ID: rule.ID, Msg: rule.Msg, Category: rule.Category, Severity: rule.Severity, Type: rule.Type,
+				Pattern: rule.Pattern, Targets: rule.Targets, View: rule.View, Transforms: rule.Transforms}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("rule = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// nocase converts only ASCII letters like Snort, so bytes of hexadecimal contents above 0x7f are matched unchanged
+func TestSnortNocase(t *testing.T) {
+	rules := strings.Join([]string{
+		`alert http any any -> any any (content:"|C0 AF|ETC"; nocase; sid:1000001;)`,
+		`alert http any any -> any any (content:"STRAßE|C3 84|"; nocase; sid:1000002;)`,
+	}, "\n")
+	detector := newTestDetector(t, "test.rules", rules, config.ServiceFunctionT{})
+
+	tests := []struct {
+		name   string
+		target string
+		want   []int
+	}{
+		{"overlong slash", "/?f=%C0%AFetc", []int{1000001}},
+		{"upper case letters", "/?f=%C0%AFEtC", []int{1000001}},
+		{"non-ascii kept", "/?s=strasse%C3%84&t=STRA%C3%9FE%C3%84", []int{1000002}},
+		{"non-ascii not folded", "/?s=stra%C3%9Fe%C3%A4", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ids := detect(t, detector, testRequest{target: test.target}); !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+func TestSnortChain(t *testing.T) {
+	rule, err := parseSnortRule(`alert http any any -> any any (http.uri; content:"/admin"; http.header; content:"curl"; nocase; sid:10;)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Chain == nil || rule.Chain.Pattern != "curl" || !reflect.DeepEqual(rule.Chain.Targets, []string{TargetHeaderLines}) {
+		t.Errorf("chain = %+v, want content 'curl' in the header lines", rule.Chain)
+	}
+}
+
+// Contents and pcre options of the URI and header buffers span the query arguments and the header names
+func TestSnortBuffers(t *testing.T) {
+	rules := strings.Join([]string{
+		`alert http any any -> any any (content:"/login.php?user="; http_uri; sid:1000001;)`,
+		`alert http any any -> any any (content:"a=1&b=2"; http_raw_uri; sid:1000002;)`,
+		`alert http any any -> any any (content:"User-Agent|3a| sqlmap"; nocase; http_header; sid:1000003;)`,
+		`alert http any any -> any any (pcre:"/^x-api-version: \$\{/Hmi"; sid:1000004;)`,
+		`alert http any any -> any any (http.cookie; content:"deleteMe"; sid:1000005;)`,
+		`alert http any any -> any any (content:"union select"; http_client_body; sid:1000006;)`,
+		`alert http any any -> any any (content:"havij"; sid:1000007;)`,
+	}, "\n")
+	detector := newTestDetector(t, "test.rules", rules, config.ServiceFunctionT{})
+
+	tests := []struct {
+		name    string
+		request testRequest
+		want    []int
+	}{
+		{"uri with query", testRequest{target: "/login.php?user=admin"}, []int{1000001}},
+		{"raw query spanning arguments", testRequest{target: "/?a=1&b=2"}, []int{1000002}},
+		{"header with name", testRequest{target: "/", headers: map[string]string{"User-Agent": "SQLMap/1.5"}}, []int{1000003}},
+		{"header pcre", testRequest{target: "/", headers: map[string]string{"X-Api-Version": "${jndi:ldap://a/b}"}}, []int{5001, 1000004}},
+		{"cookie", testRequest{target: "/", headers: map[string]string{"Cookie": "rememberMe=deleteMe"}}, []int{1000005}},
+		{"body", testRequest{method: "POST", target: "/", body: "q=1 union select 2"}, []int{1000006}},
+		{"rule without buffer", testRequest{target: "/", headers: map[string]string{"User-Agent": "havij"}},
+			[]int{1000007}},
+		{"other path", testRequest{target: "/index.php?user=admin"}, nil},
+		{"value without name", testRequest{target: "/", headers: map[string]string{"X-Agent": "sqlmap"}}, nil},
+		{"arguments in other order", testRequest{target: "/?b=2&a=1"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ids := detect(t, detector, test.request); !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+func TestImportSnortRules(t *testing.T) {
+	path := writeRuleFile(t, "test.rules", "# comment\n\n"+
+		`alert http any any -> any any (msg:"a"; content:"a"; \`+"\n"+`  sid:1;)`+"\n"+
+		`alert http any any -> any any (content:"b"; depth:3; sid:2;)`+"\n")
+	rules, skipped, err := importSnortRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].rule.ID != 1 || rules[0].line != 3 {
+		t.Errorf("rules = %+v, want rule 1 in line 3", rules)
+	}
+	if len(skipped) != 1 || skipped[0].Line != 5 || !strings.Contains(skipped[0].Reason, "depth") {
+		t.Errorf("skipped = %+v, want rule 2 in line 5", skipped)
+	}
+}
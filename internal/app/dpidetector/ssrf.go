@@ -0,0 +1,346 @@
+package dpidetector
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file detects server-side request forgery (SSRF) in arguments, whose values are URLs. The host of a URL is
+resolved syntactically without any DNS lookup: numeric hosts are parsed like inet_aton() does, so decimal
+("2130706433"), octal ("0177.0.0.1"), hexadecimal ("0x7f.1") and shortened ("127.1") notations are recognized, as
+well as IPv4 addresses embedded in IPv6 addresses ("[::ffff:127.0.0.1]"). URL parsers disagree about the host of URLs
+like "http://a.example#@127.0.0.1", so every host, which one of them could use, is checked. Hosts in loopback,
+link-local, private or configured internal networks are reported, as well as URLs with schemes, which are abused to
+talk to other protocols than HTTP.
+*/
+
+// Transforms, which are applied to a value before it is parsed as URL
+var ssrfTransforms = []string{"urlDecode", "unicodeDecode", "removeNulls"}
+
+// Schemes, with which a client can make the service talk to local files or other protocols than HTTP
+var dangerousSchemes = map[string]bool{
+	"file": true, "gopher": true, "dict": true, "ldap": true, "tftp": true, "netdoc": true,
+}
+
+// Schemes, whose URLs have a host even without "//" ("http:127.0.0.1") for the URL parsers of browsers
+var specialSchemes = map[string]bool{"http": true, "https": true, "ftp": true, "ws": true, "wss": true}
+
+// Addresses of the metadata services of cloud providers
+var metadataAddresses = []net.IP{
+	net.ParseIP("169.254.169.254"), // AWS, Azure, GCP, OpenStack and others
+	net.ParseIP("169.254.170.2"),   // AWS ECS task metadata
+	net.ParseIP("100.100.100.200"), // Alibaba Cloud
+	net.ParseIP("fd00:ec2::254"),   // AWS IPv6
+}
+
+// Host names, which refer to loopback addresses or to metadata services without a DNS lookup
+var (
+	loopbackNames = map[string]bool{"localhost": true, "localhost.localdomain": true, "localtest.me": true, "lvh.me": true}
+	metadataNames = map[string]bool{"metadata": true, "metadata.google.internal": true, "instance-data": true}
+)
+
+// Domains of wildcard DNS services, which resolve names with an embedded IP address ("127.0.0.1.nip.io") to it
+var wildcardDNSDomains = []string{".nip.io", ".sslip.io", ".xip.io"}
+
+// IPv6 prefix of NAT64 (RFC 6052), which embeds an IPv4 address in its last four bytes
+var nat64Prefix = net.ParseIP("64:ff9b::")
+
+// Scheme of a URL
+var urlScheme = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+
+var ssrfReferences = []string{
+	"https://owasp.org/www-community/attacks/Server_Side_Request_Forgery",
+	"https://cwe.mitre.org/data/definitions/918.html",
+}
+
+var (
+	ruleSSRFScheme = &Rule{
+		ID:         6001,
+		Msg:        "SSRF: URL with a scheme for local files or other protocols",
+		Category:   CategorySSRF,
+		Severity:   SeverityCritical,
+		References: ssrfReferences,
+		Action:     ActionBlock,
+	}
+	ruleSSRFMetadata = &Rule{
+		ID:         6002,
+		Msg:        "SSRF: URL targets a cloud metadata service",
+		Category:   CategorySSRF,
+		Severity:   SeverityCritical,
+		References: ssrfReferences,
+		Action:     ActionBlock,
+	}
+	ruleSSRFLoopback = &Rule{
+		ID:         6003,
+		Msg:        "SSRF: URL targets a loopback address",
+		Category:   CategorySSRF,
+		Severity:   SeverityError,
+		References: ssrfReferences,
+		Action:     ActionBlock,
+	}
+	ruleSSRFLinkLocal = &Rule{
+		ID:         6004,
+		Msg:        "SSRF: URL targets a link-local address",
+		Category:   CategorySSRF,
+		Severity:   SeverityError,
+		References: ssrfReferences,
+		Action:     ActionBlock,
+	}
+	ruleSSRFInternal = &Rule{
+		ID:         6005,
+		Msg:        "SSRF: URL targets a configured internal network",
+		Category:   CategorySSRF,
+		Severity:   SeverityError,
+		References: ssrfReferences,
+		Action:     ActionBlock,
+	}
+	ruleSSRFPrivate = &Rule{
+		ID:         6006,
+		Msg:        "SSRF: URL targets a private address",
+		Category:   CategorySSRF,
+		Severity:   SeverityWarning,
+		References: ssrfReferences,
+		Action:     ActionBlock,
+	}
+)
+
+// ssrfRules() returns the built-in rules of the SSRF detection
+func ssrfRules() []*Rule {
+	return []*Rule{ruleSSRFScheme, ruleSSRFMetadata, ruleSSRFLoopback, ruleSSRFLinkLocal, ruleSSRFInternal,
+		ruleSSRFPrivate}
+}
+
+// parseInternalNetworks() parses the configured internal networks in CIDR notation
+func parseInternalNetworks(cidrs []string) (networks []*net.IPNet, err error) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid internal network '%s': %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+/*
+This method parses the values of all arguments of the request as URLs and checks their schemes and hosts.
+
+@param request: Preprocessed request
+@param inputs: Inputs of the request (see requestInputs())
+
+@return matches: Matches of the built-in SSRF rules
+*/
+func (detector *Detector) detectSSRF(request *dpipreprocessor.Request, inputs []input) (matches []Match) {
+	for i := range inputs {
+		in := &inputs[i]
+		if in.isName || !in.belongsTo(TargetArgs) || !strings.ContainsAny(in.value, ":/\\%") {
+			continue
+		}
+		value := request.Transformed(in.index, false, dpipreprocessor.ViewNormalized, ssrfTransforms)
+		value = strings.TrimLeft(value, " \t\r\n")
+
+		scheme, hosts := urlHosts(value)
+		if dangerousSchemes[scheme] {
+			matches = append(matches, newMatch(ruleSSRFScheme, in.field, value, 0, len(scheme)+1))
+		}
+		for _, host := range hosts {
+			if rule := detector.classifyHost(value[host[0]:host[1]]); rule != nil {
+				matches = append(matches, newMatch(rule, in.field, value, host[0], host[1]))
+				break
+			}
+		}
+	}
+	return matches
+}
+
+/*
+This function extracts the scheme and the possible hosts of a URL. Besides the host of RFC 3986, the host behind the
+last "@" of the URL is returned, when the authority ends with "?", "#" or a backslash, because some URL parsers take the
+userinfo from the query or fragment or treat a backslash as part of the userinfo.
+
+@param value: URL
+
+@return scheme: Lower case scheme; empty for relative URLs
+@return hosts: Start and end offsets of the possible hosts in the value, without userinfo and port
+*/
+func urlHosts(value string) (scheme string, hosts [][2]int) {
+	start := 0
+	if loc := urlScheme.FindStringSubmatchIndex(value); loc != nil {
+		scheme = strings.ToLower(value[loc[2]:loc[3]])
+		start = loc[1]
+	}
+
+	// Browsers accept backslashes instead of slashes and special schemes even without any slash
+	slashes := start
+	for slashes < len(value) && (value[slashes] == '/' || value[slashes] == '\\') {
+		slashes++
+	}
+	switch {
+	case specialSchemes[scheme]:
+	case slashes == start:
+		return "", nil // Not a URL, but e.g. "mailto:" or "c:"
+	case slashes-start < 2:
+		return scheme, nil // URL without host like "file:/etc/passwd"
+	}
+	start = slashes
+
+	end := start + authorityEnd(value[start:])
+	hosts = append(hosts, hostOffsets(value, start, end))
+	if at := strings.LastIndexByte(value, '@'); at >= end && end < len(value) && value[end] != '/' {
+		hosts = append(hosts, hostOffsets(value, at+1, at+1+authorityEnd(value[at+1:])))
+	}
+	return scheme, hosts
+}
+
+// authorityEnd() returns the length of the authority at the beginning of a URL without its scheme
+func authorityEnd(rest string) int {
+	if end := strings.IndexAny(rest, "/\\?#"); end >= 0 {
+		return end
+	}
+	return len(rest)
+}
+
+// hostOffsets() removes the userinfo and the port from an authority and returns the offsets of its host
+func hostOffsets(value string, start, end int) [2]int {
+	if at := strings.LastIndexByte(value[start:end], '@'); at >= 0 {
+		start += at + 1
+	}
+	if strings.HasPrefix(value[start:end], "[") {
+		if closing := strings.IndexByte(value[start:end], ']'); closing >= 0 {
+			return [2]int{start, start + closing + 1}
+		}
+	}
+	if colon := strings.IndexByte(value[start:end], ':'); colon >= 0 {
+		end = start + colon
+	}
+	return [2]int{start, end}
+}
+
+// classifyHost() returns the SSRF rule, whose kind of target the host refers to; nil, when the host is not internal
+func (detector *Detector) classifyHost(host string) *Rule {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if metadataNames[host] {
+		return ruleSSRFMetadata
+	}
+	if loopbackNames[host] || strings.HasSuffix(host, ".localhost") {
+		return ruleSSRFLoopback
+	}
+
+	ip := hostIP(host)
+	if ip == nil {
+		return nil
+	}
+	for _, address := range metadataAddresses {
+		if ip.Equal(address) {
+			return ruleSSRFMetadata
+		}
+	}
+	switch {
+	case ip.IsLoopback() || ip.IsUnspecified() || (ip.To4() != nil && ip.To4()[0] == 0):
+		return ruleSSRFLoopback
+	case ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast():
+		return ruleSSRFLinkLocal
+	}
+	for _, network := range detector.internalNetworks {
+		if network.Contains(ip) {
+			return ruleSSRFInternal
+		}
+	}
+	if ip.IsPrivate() {
+		return ruleSSRFPrivate
+	}
+	return nil
+}
+
+// hostIP() returns the IP address of a lower case host; nil, when the host is a name without embedded IP address.
+// IPv4 addresses embedded in IPv6 addresses are returned as IPv4 addresses.
+func hostIP(host string) net.IP {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+		if zone := strings.IndexByte(host, '%'); zone >= 0 {
+			host = host[:zone]
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || ip.To4() != nil {
+			return ip
+		}
+		// IPv4-compatible (::127.0.0.1) and NAT64 (64:ff9b::127.0.0.1) addresses
+		compatible := ip[:12].Equal(net.IPv6zero[:12]) && !ip.Equal(net.IPv6zero) && !ip.Equal(net.IPv6loopback)
+		if compatible || ip[:12].Equal(nat64Prefix[:12]) {
+			return net.IPv4(ip[12], ip[13], ip[14], ip[15])
+		}
+		return ip
+	}
+
+	if ip := numericHost(host); ip != nil {
+		return ip
+	}
+	for _, domain := range wildcardDNSDomains {
+		if name := strings.TrimSuffix(host, domain); name != host {
+			return embeddedIP(name)
+		}
+	}
+	return nil
+}
+
+// numericHost() parses a host like inet_aton() does: one to four decimal, octal ("0177") or hexadecimal ("0x7f")
+// parts, of which the last part fills all remaining bytes of the address ("127.1", "2130706433")
+func numericHost(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	var address uint64
+	for i, part := range parts {
+		if part == "" || part[0] < '0' || part[0] > '9' {
+			return nil
+		}
+		// The base is parsed explicitly, as strconv also accepts "0b", "0o" and "_", which inet_aton() does not
+		base := 10
+		if len(part) > 1 && part[0] == '0' {
+			if part[1] == 'x' || part[1] == 'X' {
+				part, base = part[2:], 16
+			} else {
+				part, base = part[1:], 8
+			}
+		}
+		number, err := strconv.ParseUint(part, base, 32)
+		if err != nil {
+			return nil
+		}
+		if i < len(parts)-1 {
+			if number > 0xff {
+				return nil
+			}
+			address |= number << (8 * uint(3-i))
+		} else {
+			if number >= 1<<(8*uint(4-i)) {
+				return nil
+			}
+			address |= number
+		}
+	}
+	return net.IPv4(byte(address>>24), byte(address>>16), byte(address>>8), byte(address))
+}
+
+// embeddedIP() returns the IP address at the end of the name of a wildcard DNS service, which is either dotted
+// ("app.127.0.0.1") or dashed ("app-127-0-0-1")
+func embeddedIP(name string) net.IP {
+	labels := strings.Split(name, ".")
+	if len(labels) >= 4 {
+		if ip := net.ParseIP(strings.Join(labels[len(labels)-4:], ".")); ip != nil {
+			return ip
+		}
+	}
+	dashed := strings.Split(labels[len(labels)-1], "-")
+	if len(dashed) >= 4 {
+		return net.ParseIP(strings.Join(dashed[len(dashed)-4:], "."))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package dpidetector
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+func TestURLHosts(t *testing.T) {
+	tests := []struct {
+		value  string
+		scheme string
+		hosts  []string
+	}{
+		{"http://example.com/a", "http", []string{"example.com"}},
+		{"https://user:pw@10.0.0.1:8080/a", "https", []string{"10.0.0.1"}},
+		{"http://[::1]:80/", "http", []string{"[::1]"}},
+		{"http:\\\\127.0.0.1\\a", "http", []string{"127.0.0.1"}},
+		{"http:127.0.0.1", "http", []string{"127.0.0.1"}},
+		{"//169.254.169.254/latest", "", []string{"169.254.169.254"}},
+		{"http://a.example#@127.0.0.1/", "http", []string{"a.example", "127.0.0.1"}},
+		{"http://a.example\\@127.0.0.1/", "http", []string{"a.example", "127.0.0.1"}},
+		{"http://a.example/@127.0.0.1", "http", []string{"a.example"}},
+		{"file:/etc/passwd", "file", nil},
+		{"mailto:a@example.com", "", nil},
+		{"c:\\windows", "c", nil},
+	}
+	for _, test := range tests {
+		scheme, offsets := urlHosts(test.value)
+		var hosts []string
+		for _, host := range offsets {
+			hosts = append(hosts, test.value[host[0]:host[1]])
+		}
+		if scheme != test.scheme || !reflect.DeepEqual(hosts, test.hosts) {
+			t.Errorf("urlHosts(%q) = %q, %q, want %q, %q", test.value, scheme, hosts, test.scheme, test.hosts)
+		}
+	}
+}
+
+func TestHostIP(t *testing.T) {
+	tests := []struct {
+		host string
+		ip   string // Empty, when the host has no IP address
+	}{
+		{"127.0.0.1", "127.0.0.1"},
+		{"2130706433", "127.0.0.1"},
+		{"0x7f000001", "127.0.0.1"},
+		{"0177.0.0.1", "127.0.0.1"},
+		{"0x7f.1", "127.0.0.1"},
+		{"0X7F.0177.1", "127.127.0.1"},
+		{"0177.1", "127.0.0.1"},
+		{"0.0.0.0", "0.0.0.0"},
+		{"127.1", "127.0.0.1"},
+		{"10.1.65535", "10.1.255.255"},
+		{"[::ffff:169.254.169.254]", "169.254.169.254"},
+		{"[::127.0.0.1]", "127.0.0.1"},
+		{"[64:ff9b::10.0.0.1]", "10.0.0.1"},
+		{"[fe80::1%25eth0]", "fe80::1"},
+		{"127.0.0.1.nip.io", "127.0.0.1"},
+		{"app-10-0-0-1.sslip.io", "10.0.0.1"},
+		{"256.0.0.1", ""},
+		{"1.2.3.4.5", ""},
+		{"0x100.0.0.1", ""},
+		{"1e3", ""},
+		{"0b1111111.1", ""},
+		{"0o177.1", ""},
+		{"1_27.1", ""},
+		{"0x.1", ""},
+		{"example.com", ""},
+	}
+	for _, test := range tests {
+		ip := hostIP(test.host)
+		if test.ip == "" && ip != nil || test.ip != "" && !ip.Equal(net.ParseIP(test.ip)) {
+			t.Errorf("hostIP(%q) = %v, want %q", test.host, ip, test.ip)
+		}
+	}
+}
+
+func TestDetectSSRF(t *testing.T) {
+	detector := newTestDetector(t, "", "", config.ServiceFunctionT{InternalNetworks: []string{"203.0.113.0/24"}})
+
+	tests := []struct {
+		name  string
+		value string
+		want  []int
+	}{
+		{"metadata service", "http://169.254.169.254/latest/meta-data/", []int{6002}},
+		{"metadata name", "http://metadata.google.internal/computeMetadata/v1/", []int{6002}},
+		{"decimal loopback", "http://2130706433/admin", []int{6003}},
+		{"localhost subdomain", "http://app.localhost:8080/", []int{6003}},
+		{"unspecified address", "http://0.0.0.0:22/", []int{6003}},
+		{"link-local", "http://169.254.1.1/", []int{6004}},
+		{"configured network", "https://203.0.113.7/", []int{6005}},
+		{"private address", "http://192.168.0.1/router", []int{6006}},
+		{"dangerous scheme", "gopher://example.com:6379/_INFO", []int{6001}},
+		{"file scheme", "file:///etc/passwd", []int{6001}},
+		{"fragment confusion", "http://a.example#@127.0.0.1/", []int{6003}},
+		{"double encoding", "http%253A%252F%252F127.0.0.1%252F", []int{6003}},
+		{"fullwidth digits", "http://１２７.０.０.１/", []int{6003}},
+		{"public host", "https://example.com/callback", nil},
+		{"public address", "http://93.184.216.34/", nil},
+		{"relative path", "/images/a.png", nil},
+		{"time", "12:30", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := testRequest{target: "/fetch?url=" + url.QueryEscape(test.value)}
+			ids := categoryIDs(detect(t, detector, request), CategorySSRF)
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package dpidetector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file maps the fields of a preprocessed request to the targets of the rules. A target is a collection of fields,
+e.g. all query arguments. It can be restricted to the fields with a certain name ("headers:user-agent") or a name
+matching a regular expression ("cookies:/^session/"). A field can be excluded from the targets of a rule by an
+exclusion ("!args:password"). Rules without targets apply to the values of all fields, but not to their names.
+The whole request URI, the whole query, all header lines and the canonical path repeat other fields as a whole, so
+they are only inspected by rules, which target them explicitly.
+*/
+
+// Rule targets, which define the parts of a request a rule is applied to
+const (
+	TargetURL         = "url" // Path and query arguments, including a fragment sent by a client
+	TargetPath        = "path"
+	TargetArgs        = "args" // Query arguments and the arguments of form and structured bodies
+	TargetQuery       = "query"
+	TargetArgsNames   = "args_names"
+	TargetHeaders     = "headers"
+	TargetHeaderNames = "header_names"
+	TargetCookies     = "cookies"
+	TargetCookieNames = "cookie_names"
+	TargetBody        = "body"         // Raw body
+	TargetJSON        = "json"         // Values of a JSON body
+	TargetForm        = "form"         // Arguments of an URL-encoded or multipart form body
+	TargetFiles       = "files"        // File names of the uploads of a multipart body
+	TargetXML         = "xml"          // Element texts and attribute values of an XML body
+	TargetRequestURI  = "request_uri"  // Path and query as a single string, e.g. "/login.php?user=a&pw=b"
+	TargetQueryString = "query_string" // Query as a single string
+	TargetHeaderLines = "header_lines" // All headers as lines "Name: value" in a single string
+	// Path with resolved dot segments, e.g. "/admin/" for "/public/..;/admin/"
+	TargetCanonicalPath = "canonical_path"
+)
+
+// Targets, which contain the values and the names of the fields of a location
+var (
+	locationValueTargets = map[string][]string{
+		dpipreprocessor.LocationPath:   {TargetURL, TargetPath},
+		dpipreprocessor.LocationQuery:  {TargetURL, TargetArgs, TargetQuery},
+		dpipreprocessor.LocationHeader: {TargetHeaders},
+		dpipreprocessor.LocationCookie: {TargetCookies},
+		dpipreprocessor.LocationBody:   {TargetBody},
+		dpipreprocessor.LocationJSON:   {TargetArgs, TargetJSON},
+		dpipreprocessor.LocationForm:   {TargetArgs, TargetForm},
+		dpipreprocessor.LocationFile:   {TargetFiles},
+		dpipreprocessor.LocationXML:    {TargetArgs, TargetXML},
+
+		dpipreprocessor.LocationRequestURI:  {TargetRequestURI},
+		dpipreprocessor.LocationQueryString: {TargetQueryString},
+		dpipreprocessor.LocationHeaderLines: {TargetHeaderLines},
+
+		dpipreprocessor.LocationCanonicalPath: {TargetCanonicalPath},
+	}
+	locationNameTargets = map[string][]string{
+		dpipreprocessor.LocationQuery:  {TargetArgsNames},
+		dpipreprocessor.LocationHeader: {TargetHeaderNames},
+		dpipreprocessor.LocationCookie: {TargetCookieNames},
+		dpipreprocessor.LocationJSON:   {TargetArgsNames},
+		dpipreprocessor.LocationForm:   {TargetArgsNames},
+		dpipreprocessor.LocationXML:    {TargetArgsNames},
+	}
+	validTargets = map[string]bool{
+		TargetURL: true, TargetPath: true, TargetArgs: true, TargetQuery: true, TargetArgsNames: true,
+		TargetHeaders: true, TargetHeaderNames: true, TargetCookies: true, TargetCookieNames: true, TargetBody: true,
+		TargetJSON: true, TargetForm: true, TargetFiles: true, TargetXML: true, TargetRequestURI: true,
+		TargetQueryString: true, TargetHeaderLines: true, TargetCanonicalPath: true,
+	}
+	// Targets, which are only inspected by rules naming them
+	explicitTargets = map[string]bool{TargetRequestURI: true, TargetQueryString: true, TargetHeaderLines: true,
+		TargetCanonicalPath: true}
+)
+
+// ruleTarget is a compiled target of a rule
+type ruleTarget struct {
+	collection string
+	name       string
+	nameRegex  *regexp.Regexp
+	exclude    bool
+}
+
+// input is a single value of a request, which is inspected by the rules
+type input struct {
+	index   int      // Index of the field in the request
+	targets []string // Targets, which contain the input
+	name    string   // Name of the field
+	isName  bool     // The input is the name of a field
+	field   string   // Description of the field for matches, e.g. "args:id"
+	value   string
+
+	// The input repeats other inputs as a whole and is only inspected by rules targeting it (see explicitTargets)
+	explicit bool
+}
+
+// parseTarget() compiles a target of a rule
+func parseTarget(text string) (target ruleTarget, err error) {
+	if strings.HasPrefix(text, "!") {
+		target.exclude = true
+		text = text[1:]
+	}
+
+	target.collection = strings.ToLower(text)
+	if colon := strings.Index(text, ":"); colon >= 0 {
+		target.collection, target.name = strings.ToLower(text[:colon]), text[colon+1:]
+		if len(target.name) > 1 && strings.HasPrefix(target.name, "/") && strings.HasSuffix(target.name, "/") {
+			target.nameRegex, err = regexp.Compile("(?i)" + target.name[1:len(target.name)-1])
+			if err != nil {
+				return target, fmt.Errorf("invalid name of target '%s': %w", text, err)
+			}
+		}
+		target.name = strings.ToLower(target.name)
+	}
+
+	if !validTargets[target.collection] {
+		return target, fmt.Errorf("unknown target '%s'", text)
+	}
+	if target.exclude && target.name == "" {
+		return target, fmt.Errorf("exclusion '!%s' does not name a field", text)
+	}
+	return target, nil
+}
+
+// matches() checks if the input is part of the target
+func (target ruleTarget) matches(in *input) bool {
+	if !in.belongsTo(target.collection) {
+		return false
+	}
+	switch {
+	case target.nameRegex != nil:
+		return target.nameRegex.MatchString(in.name)
+	case target.name != "":
+		return target.name == in.name
+	}
+	return true
+}
+
+func (in *input) belongsTo(target string) bool {
+	for _, t := range in.targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// requestInputs() converts the fields of a request into the inputs for the rules
+func requestInputs(request *dpipreprocessor.Request) (inputs []input) {
+	for i, field := range request.Fields {
+		targets := locationValueTargets[field.Location]
+		inputs = append(inputs, input{index: i, targets: targets, name: field.Name, explicit: explicitTargets[targets[0]],
+			field: fieldDescription(field.Location, field.Name), value: field.Value})
+
+		if nameTargets, ok := locationNameTargets[field.Location]; ok && field.Name != "" {
+			inputs = append(inputs, input{index: i, targets: nameTargets, name: field.Name, isName: true,
+				field: nameTargets[0] + ":" + field.Name, value: field.Name})
+		}
+	}
+	return inputs
+}
+
+// fieldDescription() names a field of a request by its most specific target and its name, e.g. "args:id"
+func fieldDescription(location, name string) string {
+	targets := locationValueTargets[location]
+	description := targets[len(targets)-1]
+	if name != "" {
+		description += ":" + name
+	}
+	return description
+}
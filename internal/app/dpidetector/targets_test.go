@@ -0,0 +1,88 @@
+package dpidetector
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+func TestRuleTargets(t *testing.T) {
+	// Every rule searches "evil" in other targets
+	targets := []string{
+		"[]",                      // 1000001
+		"[path]",                  // 1000002
+		"[args]",                  // 1000003
+		"[args, '!args:safe']",    // 1000004
+		"[args_names]",            // 1000005
+		"['headers:user-agent']",  // 1000006
+		"['cookies:/^sess/']",     // 1000007
+		"[header_names, cookies]", // 1000008
+		"[form]",                  // 1000009
+	}
+	var rules strings.Builder
+	rules.WriteString("rules:\n")
+	for i, target := range targets {
+		fmt.Fprintf(&rules, "  - {id: %d, category: test, severity: notice, type: literal, pattern: evil, targets: %s}\n", 1000001+i, target)
+	}
+	detector := newTestDetector(t, "test.yml", rules.String(), config.ServiceFunctionT{})
+
+	tests := []struct {
+		name    string
+		request testRequest
+		want    []int
+	}{
+		{"path", testRequest{target: "/evil"}, []int{1000001, 1000002}},
+		{"query argument", testRequest{target: "/?q=evil"}, []int{1000001, 1000003, 1000004}},
+		{"excluded argument", testRequest{target: "/?safe=evil"}, []int{1000001, 1000003}},
+		{"argument name", testRequest{target: "/?evil=1"}, []int{1000005}},
+		{"named header", testRequest{target: "/", headers: map[string]string{"User-Agent": "evil"}}, []int{1000001, 1000006}},
+		{"other header", testRequest{target: "/", headers: map[string]string{"Referer": "evil"}}, []int{1000001}},
+		{"header name", testRequest{target: "/", headers: map[string]string{"X-Evil": "1"}}, []int{1000008}},
+		{"cookie matching the name pattern", testRequest{target: "/", headers: map[string]string{"Cookie": "session=evil"}},
+			[]int{1000001, 1000007, 1000008}},
+		{"other cookie", testRequest{target: "/", headers: map[string]string{"Cookie": "theme=evil"}},
+			[]int{1000001, 1000008}},
+		{"form argument", testRequest{method: "POST", target: "/", body: "q=evil",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}},
+			[]int{1000001, 1000001, 1000003, 1000004, 1000009}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ids := detect(t, detector, test.request); !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		text string
+		want ruleTarget
+		err  string
+	}{
+		{"ARGS", ruleTarget{collection: TargetArgs}, ""},
+		{"headers:User-Agent", ruleTarget{collection: TargetHeaders, name: "user-agent"}, ""},
+		{"!args:password", ruleTarget{collection: TargetArgs, name: "password", exclude: true}, ""},
+		{"params", ruleTarget{}, "unknown target 'params'"},
+		{"!cookies", ruleTarget{}, "exclusion '!cookies' does not name a field"},
+		{"cookies:/(/", ruleTarget{}, "invalid name of target 'cookies:/(/'"},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			target, err := parseTarget(test.text)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(target, test.want) {
+				t.Errorf("target = %+v, error = %v, want %+v", target, err, test.want)
+			}
+		})
+	}
+}
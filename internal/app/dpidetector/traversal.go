@@ -0,0 +1,109 @@
+package dpidetector
+
+import (
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file detects path traversal by canonicalizing the requested path and all argument values. In contrast to the
+signatures of the rule files, the check does not depend on the notation of the traversal: a value is reported, when
+its canonical form escapes the root directory or refers to one of the sensitive files of the operating system.
+*/
+
+// Sensitive files, which are used when no sensitive files are configured
+var DefaultSensitiveFiles = []string{
+	"/etc/passwd",
+	"/etc/shadow",
+	"/etc/group",
+	"/etc/hosts",
+	"/etc/issue",
+	"/proc/self/environ",
+	"/proc/self/cmdline",
+	"/root/.ssh/id_rsa",
+	"/root/.bash_history",
+	"c:\\boot.ini",
+	"c:\\windows\\win.ini",
+	"c:\\windows\\system.ini",
+	"c:\\windows\\system32\\drivers\\etc\\hosts",
+}
+
+// Transforms, which are applied to a value before it is canonicalized
+var traversalTransforms = []string{"urlDecode", "unicodeDecode", "lowercase"}
+
+var (
+	ruleTraversalEscape = &Rule{
+		ID:       1003,
+		Msg:      "Path traversal: path escapes the root directory",
+		Category: CategoryPathTraversal,
+		Severity: SeverityCritical,
+		References: []string{
+			"https://owasp.org/www-community/attacks/Path_Traversal",
+			"https://cwe.mitre.org/data/definitions/22.html",
+		},
+		Action: ActionBlock,
+	}
+	ruleSensitiveFile = &Rule{
+		ID:       1004,
+		Msg:      "Path traversal: access to a sensitive file",
+		Category: CategoryPathTraversal,
+		Severity: SeverityCritical,
+		References: []string{
+			"https://owasp.org/www-community/attacks/Path_Traversal",
+			"https://cwe.mitre.org/data/definitions/22.html",
+		},
+		Action: ActionBlock,
+	}
+)
+
+// sensitiveFilePaths() converts the configured sensitive files into the form, which is compared with canonical paths
+func sensitiveFilePaths(files []string) (paths []string) {
+	if len(files) == 0 {
+		files = DefaultSensitiveFiles
+	}
+	for _, file := range files {
+		paths = append(paths, comparablePath(strings.ToLower(file)))
+	}
+	return paths
+}
+
+// comparablePath() canonicalizes a path and removes its drive letter and leading slash
+func comparablePath(value string) string {
+	canonical, _ := dpipreprocessor.CanonicalPath(value)
+	if len(canonical) >= 2 && canonical[1] == ':' {
+		canonical = canonical[2:]
+	}
+	return strings.TrimPrefix(canonical, "/")
+}
+
+/*
+This method canonicalizes the path and the values of all arguments of the request and checks, if they escape the
+root directory or refer to a sensitive file. Values are truncated at the first null byte, like the file APIs of most
+languages do.
+
+@param request: Preprocessed request
+@param inputs: Inputs of the request (see requestInputs())
+
+@return matches: Matches of the built-in path traversal rules
+*/
+func (detector *Detector) detectTraversal(request *dpipreprocessor.Request, inputs []input) (matches []Match) {
+	for i := range inputs {
+		in := &inputs[i]
+		if in.isName || !(in.belongsTo(TargetPath) || in.belongsTo(TargetArgs)) || !strings.ContainsAny(in.value, "./\\%") {
+			continue
+		}
+		value := request.Transformed(in.index, false, dpipreprocessor.ViewNormalized, traversalTransforms)
+		if i := strings.IndexByte(value, 0); i >= 0 {
+			value = value[:i]
+		}
+
+		if _, escapes := dpipreprocessor.CanonicalPath(value); escapes {
+			matches = append(matches, newMatch(ruleTraversalEscape, in.field, value, 0, len(value)))
+		}
+		if containsFile(detector.sensitiveFiles, comparablePath(value)) {
+			matches = append(matches, newMatch(ruleSensitiveFile, in.field, value, 0, len(value)))
+		}
+	}
+	return matches
+}
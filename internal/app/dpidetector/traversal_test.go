@@ -0,0 +1,67 @@
+package dpidetector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+func TestDetectTraversal(t *testing.T) {
+	detector := newTestDetector(t, "", "", config.ServiceFunctionT{})
+	custom := newTestDetector(t, "", "", config.ServiceFunctionT{SensitiveFiles: []string{"/opt/app/secret.key"}})
+
+	tests := []struct {
+		name     string
+		detector *Detector
+		target   string
+		want     []int
+	}{
+		{"escaping argument", detector, "/download?file=../../../../etc/passwd", []int{1003, 1004}},
+		{"double encoding", detector, "/download?file=%252e%252e%252fetc%252fpasswd", []int{1003, 1004}},
+		{"overlong encoding", detector, "/download?file=..%c0%af..%c0%afetc%c0%afpasswd", []int{1003, 1004}},
+		{"backslashes and drive", detector, "/?f=C:%5CWindows%5Cwin.ini", []int{1004}},
+		{"absolute sensitive file", detector, "/?f=/etc/shadow", []int{1004}},
+		{"null byte", detector, "/?f=../../etc/passwd%00.png", []int{1003, 1004}},
+		{"path parameter", detector, "/static/..;/..;/admin", []int{1003}},
+		{"configured file", custom, "/?f=/opt/app/./secret.key", []int{1004}},
+		{"default file not configured", custom, "/?f=/etc/passwd", nil},
+		{"relative path within root", detector, "/?f=images/../logo.png", nil},
+		{"file name with dots", detector, "/?f=report..pdf", nil},
+		{"passwd elsewhere", detector, "/?f=/home/etc/passwd.txt", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids := categoryIDs(detect(t, test.detector, testRequest{target: test.target}), CategoryPathTraversal)
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+// Rules can refer to the canonical path, which is only inspected by rules naming it
+func TestCanonicalPathTarget(t *testing.T) {
+	detector := newTestDetector(t, "test.yml", `rules:
+  - {id: 1000001, category: test, severity: notice, type: regex, pattern: '^/admin(/|$)', targets: [canonical_path]}
+  - {id: 1000002, category: test, severity: notice, type: literal, pattern: 'admin'}
+`, config.ServiceFunctionT{})
+
+	tests := []struct {
+		target string
+		want   []int
+	}{
+		{"/admin/users", []int{1000001, 1000002}},
+		{"/public/..;/admin/", []int{1000001, 1000002}},
+		{"/public/%2e%2e/admin", []int{1000001, 1000002}},
+		{"/public/admin", []int{1000002}},
+	}
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			ids := detect(t, detector, testRequest{target: test.target})
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
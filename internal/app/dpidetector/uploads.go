@@ -0,0 +1,288 @@
+package dpidetector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file checks the file uploads of multipart/form-data bodies against the upload policy of the requested route.
+A policy limits the size of every file and of all files together, the allowed and denied file extensions and the
+allowed content types. Independent of the policy, file names, which escape the upload directory, and files, whose
+content does not match their declared content type, are reported. Older browsers send the full client path of a file
+(e.g. "C:\Users\a\doc.pdf"), so a directory in a file name is no finding on its own.
+*/
+
+// Defaults of an upload policy
+const (
+	DefaultMaxFileSize  = 10 << 20
+	DefaultMaxTotalSize = 50 << 20
+)
+
+// Extensions of executable files and server side scripts, which are denied when a policy defines no denied extensions
+var DefaultDeniedExtensions = []string{
+	".php", ".php3", ".php4", ".php5", ".phtml", ".phar", ".asp", ".aspx", ".ashx", ".jsp", ".jspx", ".cgi",
+	".pl", ".py", ".sh", ".exe", ".dll", ".bat", ".cmd", ".ps1", ".htaccess",
+}
+
+// Aliases of media types, which http.DetectContentType() reports under another name
+var mediaTypeAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-png":                  "image/png",
+	"application/x-pdf":            "application/pdf",
+	"application/x-zip-compressed": "application/zip",
+	"application/gzip":             "application/x-gzip",
+	"image/vnd.microsoft.icon":     "image/x-icon",
+	"audio/wav":                    "audio/wave",
+	"audio/x-wav":                  "audio/wave",
+	"audio/mp3":                    "audio/mpeg",
+	"video/x-msvideo":              "video/avi",
+}
+
+// Media types, which can be recognized by their magic bytes, apart from images, audio and video
+var magicMediaTypes = map[string]bool{
+	"application/pdf":              true,
+	"application/zip":              true,
+	"application/x-gzip":           true,
+	"application/x-rar-compressed": true,
+	"application/wasm":             true,
+	"application/ogg":              true,
+}
+
+var uploadReferences = []string{
+	"https://owasp.org/www-community/vulnerabilities/Unrestricted_File_Upload",
+	"https://cwe.mitre.org/data/definitions/434.html",
+}
+
+var (
+	ruleUploadTraversal = &Rule{
+		ID:       1005,
+		Msg:      "Path traversal: file name of an upload escapes the upload directory",
+		Category: CategoryPathTraversal,
+		Severity: SeverityCritical,
+		References: []string{
+			"https://owasp.org/www-community/attacks/Path_Traversal",
+			"https://cwe.mitre.org/data/definitions/22.html",
+		},
+		Action: ActionBlock,
+	}
+	ruleUploadExtension = &Rule{
+		ID:         8001,
+		Msg:        "File upload: file extension is not allowed",
+		Category:   CategoryFileUpload,
+		Severity:   SeverityCritical,
+		References: uploadReferences,
+		Action:     ActionBlock,
+	}
+	ruleUploadMagic = &Rule{
+		ID:         8002,
+		Msg:        "File upload: content does not match the declared content type",
+		Category:   CategoryFileUpload,
+		Severity:   SeverityError,
+		References: uploadReferences,
+		Action:     ActionBlock,
+	}
+	ruleUploadContentType = &Rule{
+		ID:         8003,
+		Msg:        "File upload: content type is not allowed",
+		Category:   CategoryFileUpload,
+		Severity:   SeverityError,
+		References: uploadReferences,
+		Action:     ActionBlock,
+	}
+	ruleUploadFileSize = &Rule{
+		ID:         8004,
+		Msg:        "File upload: file exceeds the maximum size",
+		Category:   CategoryFileUpload,
+		Severity:   SeverityError,
+		References: uploadReferences,
+		Action:     ActionBlock,
+	}
+	ruleUploadTotalSize = &Rule{
+		ID:         8005,
+		Msg:        "File upload: files exceed the maximum total size",
+		Category:   CategoryFileUpload,
+		Severity:   SeverityError,
+		References: uploadReferences,
+		Action:     ActionBlock,
+	}
+)
+
+// uploadRules() returns the built-in rules of the upload checks
+func uploadRules() []*Rule {
+	return []*Rule{ruleUploadTraversal, ruleUploadExtension, ruleUploadMagic, ruleUploadContentType,
+		ruleUploadFileSize, ruleUploadTotalSize}
+}
+
+// uploadPolicy is an upload policy of the configuration with its defaults applied
+type uploadPolicy struct {
+	path                string
+	maxFileSize         int64
+	maxTotalSize        int64
+	allowedExtensions   map[string]bool
+	deniedExtensions    map[string]bool
+	allowedContentTypes map[string]bool
+}
+
+// newUploadPolicies() applies the defaults to the configured policies and sorts them by descending path length, so
+// the most specific policy of a path is found first. The last policy is the default policy for all paths.
+func newUploadPolicies(configs []config.UploadPolicyT) (policies []*uploadPolicy) {
+	hasDefault := false
+	for _, c := range configs {
+		path, _ := dpipreprocessor.CanonicalPath(strings.ToLower(c.Path))
+		policy := &uploadPolicy{
+			path:                strings.TrimSuffix(path, "/"),
+			maxFileSize:         c.MaxFileSize,
+			maxTotalSize:        c.MaxTotalSize,
+			allowedExtensions:   extensionSet(c.AllowedExtensions),
+			deniedExtensions:    extensionSet(c.DeniedExtensions),
+			allowedContentTypes: make(map[string]bool),
+		}
+		if policy.maxFileSize == 0 {
+			policy.maxFileSize = DefaultMaxFileSize
+		}
+		if policy.maxTotalSize == 0 {
+			policy.maxTotalSize = DefaultMaxTotalSize
+		}
+		if len(c.DeniedExtensions) == 0 {
+			policy.deniedExtensions = extensionSet(DefaultDeniedExtensions)
+		}
+		for _, contentType := range c.AllowedContentTypes {
+			policy.allowedContentTypes[normalizedMediaType(contentType)] = true
+		}
+		hasDefault = hasDefault || policy.path == ""
+		policies = append(policies, policy)
+	}
+	if !hasDefault {
+		policies = append(policies, &uploadPolicy{maxFileSize: DefaultMaxFileSize, maxTotalSize: DefaultMaxTotalSize,
+			deniedExtensions: extensionSet(DefaultDeniedExtensions)})
+	}
+
+	sort.SliceStable(policies, func(i, j int) bool {
+		return len(policies[i].path) > len(policies[j].path)
+	})
+	return policies
+}
+
+// extensionSet() converts a list of file extensions into a set of lower case extensions with a leading dot
+func extensionSet(extensions []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, extension := range extensions {
+		set["."+strings.TrimPrefix(strings.ToLower(extension), ".")] = true
+	}
+	return set
+}
+
+// normalizedMediaType() converts a media type into lower case and replaces aliases
+func normalizedMediaType(mediaType string) string {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if alias, ok := mediaTypeAliases[mediaType]; ok {
+		return alias
+	}
+	return mediaType
+}
+
+// uploadPolicy() returns the policy of the route, to which the path belongs
+func (detector *Detector) uploadPolicy(path string) *uploadPolicy {
+	canonical, _ := dpipreprocessor.CanonicalPath(strings.ToLower(path))
+	for _, policy := range detector.uploadPolicies {
+		if policy.path == "" || canonical == policy.path || strings.HasPrefix(canonical, policy.path+"/") {
+			return policy
+		}
+	}
+	return nil
+}
+
+/*
+This method checks all uploads of a request against the upload policy of the requested route.
+
+@param request: Preprocessed request
+
+@return matches: Matches of the built-in upload rules
+*/
+func (detector *Detector) detectUploads(request *dpipreprocessor.Request) (matches []Match) {
+	if len(request.Uploads) == 0 {
+		return nil
+	}
+	policy := detector.uploadPolicy(request.Path)
+
+	var total int64
+	for _, upload := range request.Uploads {
+		field := fieldDescription(dpipreprocessor.LocationFile, upload.Name)
+		total += upload.Size
+
+		// File APIs of many languages stop at a null byte, so "shell.php\x00.jpg" is stored as "shell.php"
+		filename := upload.Filename
+		if i := strings.IndexByte(filename, 0); i >= 0 {
+			filename = filename[:i]
+		}
+
+		if _, escapes := dpipreprocessor.CanonicalPath(filename); escapes {
+			matches = append(matches, newMatch(ruleUploadTraversal, field, upload.Filename, 0, len(upload.Filename)))
+		}
+		if !policy.allowsFilename(filename) {
+			matches = append(matches, newMatch(ruleUploadExtension, field, upload.Filename, 0, len(upload.Filename)))
+		}
+
+		declared := normalizedMediaType(upload.ContentType)
+		if len(policy.allowedContentTypes) > 0 && !policy.allowedContentTypes[declared] {
+			matches = append(matches, newMatch(ruleUploadContentType, field, upload.ContentType, 0, len(upload.ContentType)))
+		}
+		if mismatchesMagic(declared, upload.DetectedType) {
+			description := fmt.Sprintf("declared %s, detected %s", upload.ContentType, upload.DetectedType)
+			matches = append(matches, newMatch(ruleUploadMagic, field, description, 0, len(description)))
+		}
+
+		if upload.Size > policy.maxFileSize {
+			description := fmt.Sprintf("%d bytes (maximum %d)", upload.Size, policy.maxFileSize)
+			if upload.Truncated {
+				description = fmt.Sprintf("%d bytes according to the Content-Length (maximum %d)", upload.Size, policy.maxFileSize)
+			}
+			matches = append(matches, newMatch(ruleUploadFileSize, field, description, 0, len(description)))
+		}
+	}
+
+	if total > policy.maxTotalSize {
+		description := fmt.Sprintf("%d bytes (maximum %d)", total, policy.maxTotalSize)
+		matches = append(matches, newMatch(ruleUploadTotalSize, TargetFiles, description, 0, len(description)))
+	}
+	return matches
+}
+
+// allowsFilename() checks every extension of a file name, so double extensions like "shell.php.jpg" are detected
+func (policy *uploadPolicy) allowsFilename(filename string) bool {
+	if i := strings.LastIndexAny(filename, "/\\"); i >= 0 {
+		filename = filename[i+1:]
+	}
+	filename = strings.ToLower(strings.TrimRight(filename, ". "))
+
+	parts := strings.Split(filename, ".")
+	for _, part := range parts[1:] {
+		if policy.deniedExtensions["."+part] {
+			return false
+		}
+	}
+	if len(policy.allowedExtensions) > 0 {
+		return len(parts) > 1 && policy.allowedExtensions["."+parts[len(parts)-1]]
+	}
+	return true
+}
+
+// mismatchesMagic() checks if the detected media type of a file contradicts its declared media type. Only declared
+// types, which can be recognized by their magic bytes, are verified. Unknown binary content is not reported.
+func mismatchesMagic(declared, detected string) bool {
+	detected = normalizedMediaType(detected)
+	if declared == "" || detected == "" || detected == "application/octet-stream" || declared == detected {
+		return false
+	}
+	if strings.HasSuffix(declared, "+xml") { // e.g. SVG images are text
+		return false
+	}
+	mediaClass := declared[:strings.IndexByte(declared+"/", '/')]
+	return mediaClass == "image" || mediaClass == "audio" || mediaClass == "video" || magicMediaTypes[declared]
+}
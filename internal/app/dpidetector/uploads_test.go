@@ -0,0 +1,116 @@
+package dpidetector
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+// Beginning of a PNG image, which is recognized by http.DetectContentType()
+var pngHeader = "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"
+
+// testFile is a file of a multipart body
+type testFile struct {
+	filename, contentType, content string
+}
+
+// uploadRequest() creates a request, which uploads the files in a multipart/form-data body
+func uploadRequest(t testing.TB, target string, files ...testFile) testRequest {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="file"; filename="`+file.filename+`"`)
+		header.Set("Content-Type", file.contentType)
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(file.content))
+	}
+	writer.Close()
+	return testRequest{method: "POST", target: target, body: body.String(),
+		headers: map[string]string{"Content-Type": writer.FormDataContentType()}}
+}
+
+func TestDetectUploads(t *testing.T) {
+	detector := newTestDetector(t, "", "", config.ServiceFunctionT{Body: config.BodyT{Uploads: []config.UploadPolicyT{
+		{Path: "/avatars", MaxFileSize: 1000, MaxTotalSize: 1500, AllowedExtensions: []string{".png", ".jpg"},
+			AllowedContentTypes: []string{"image/png", "image/jpeg"}},
+	}}})
+	png := testFile{"me.png", "image/png", pngHeader}
+
+	tests := []struct {
+		name    string
+		request testRequest
+		want    []int
+	}{
+		{"allowed image", uploadRequest(t, "/avatars/upload", png), nil},
+		{"script", uploadRequest(t, "/docs", testFile{"shell.php", "text/plain", "<?php system($_GET[c]);"}),
+			[]int{8001}},
+		{"double extension", uploadRequest(t, "/docs", testFile{"shell.php.jpg", "image/jpeg", "<?php"}),
+			[]int{8001, 8002}},
+		{"trailing dot", uploadRequest(t, "/docs", testFile{"shell.php.", "text/plain", "a"}), []int{8001}},
+		{"directory", uploadRequest(t, "/docs", testFile{"../../var/www/a.txt", "text/plain", "a"}), []int{1005}},
+		{"windows directory", uploadRequest(t, "/docs", testFile{`a\..\..\web.config`, "text/plain", "a"}), []int{1005}},
+		{"client path", uploadRequest(t, "/docs", testFile{`C:\Users\a\doc.txt`, "text/plain", "a"}), nil},
+		{"client path of a script", uploadRequest(t, "/docs", testFile{"/home/a/shell.php", "text/plain", "a"}),
+			[]int{8001}},
+		{"extension not allowed", uploadRequest(t, "/avatars", testFile{"me.gif", "image/png", pngHeader}),
+			[]int{8001}},
+		{"content type not allowed", uploadRequest(t, "/avatars", testFile{"me.png", "image/gif", pngHeader}),
+			[]int{8002, 8003}},
+		{"file too large", uploadRequest(t, "/avatars",
+			testFile{"me.png", "image/png", pngHeader + strings.Repeat("x", 1000)}), []int{8004}},
+		{"files too large together", uploadRequest(t, "/avatars",
+			testFile{"a.png", "image/png", pngHeader + strings.Repeat("x", 900)},
+			testFile{"b.png", "image/png", pngHeader + strings.Repeat("x", 900)}), []int{8005}},
+		{"other route", uploadRequest(t, "/avatarsx", testFile{"me.gif", "image/gif", "GIF89a"}), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids := detect(t, detector, test.request)
+			var uploadIDs []int
+			for _, id := range ids {
+				if id == 1005 || id/1000 == 8 {
+					uploadIDs = append(uploadIDs, id)
+				}
+			}
+			if !reflect.DeepEqual(uploadIDs, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+// An upload, which is cut at the inspection limit, is checked against the maximum file size with the size according
+// to the Content-Length
+func TestDetectTruncatedUpload(t *testing.T) {
+	detector := newTestDetector(t, "", "", config.ServiceFunctionT{})
+	request := uploadRequest(t, "/upload", testFile{"video.mp4", "video/mp4", strings.Repeat("x", 12<<20)})
+
+	data := request.preprocess()
+	defer data.Close()
+	if len(data.Uploads) != 1 || !data.Uploads[0].Truncated {
+		t.Fatalf("uploads = %+v, want one truncated upload", data.Uploads)
+	}
+	if size := data.Uploads[0].Size; size != 12<<20 {
+		t.Errorf("size = %d, want %d", size, 12<<20)
+	}
+	var sizeMatch *Match
+	for _, match := range detector.Detect(data) {
+		if match.RuleID == ruleUploadFileSize.ID {
+			found := match
+			sizeMatch = &found
+		}
+	}
+	if sizeMatch == nil || !strings.Contains(sizeMatch.Matched, "according to the Content-Length") {
+		t.Errorf("match = %+v, want rule %d", sizeMatch, ruleUploadFileSize.ID)
+	}
+}
@@ -0,0 +1,136 @@
+package dpidetector
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
+)
+
+/*
+This file detects XML external entity (XXE) attacks in the DOCTYPE declarations of XML bodies. The Preprocessor never
+expands entities, so the declarations are only analyzed: external entities (SYSTEM or PUBLIC) and entities, whose
+expansion would exceed maxEntityExpansion bytes or which refer to themselves, are reported.
+*/
+
+// Maximum number of bytes, to which the entities of a DOCTYPE may expand
+const maxEntityExpansion = 100000
+
+// Entity declaration: <!ENTITY [%] name [SYSTEM|PUBLIC] "value">
+var entityDeclaration = regexp.MustCompile(`(?is)<!ENTITY\s+(%\s*)?([^\s"'>]+)\s+(?:(SYSTEM|PUBLIC)\b\s*)?(?:"([^"]*)"|'([^']*)')`)
+
+// Reference to a general (&name;) or parameter entity (%name;)
+var entityReference = regexp.MustCompile(`[&%]([^;\s&%]+);`)
+
+var xxeReferences = []string{
+	"https://owasp.org/www-community/vulnerabilities/XML_External_Entity_(XXE)_Processing",
+	"https://cwe.mitre.org/data/definitions/611.html",
+}
+
+var (
+	ruleXMLDoctype = &Rule{
+		ID:         7001,
+		Msg:        "XXE: DOCTYPE declaration in XML body",
+		Category:   CategoryXXE,
+		Severity:   SeverityWarning,
+		References: xxeReferences,
+		Action:     ActionBlock,
+	}
+	ruleXMLExternalEntity = &Rule{
+		ID:         7002,
+		Msg:        "XXE: external entity declaration",
+		Category:   CategoryXXE,
+		Severity:   SeverityCritical,
+		References: xxeReferences,
+		Action:     ActionBlock,
+	}
+	ruleXMLEntityBomb = &Rule{
+		ID:       7003,
+		Msg:      "XXE: entity expansion bomb",
+		Category: CategoryXXE,
+		Severity: SeverityCritical,
+		References: []string{
+			"https://en.wikipedia.org/wiki/Billion_laughs_attack",
+			"https://cwe.mitre.org/data/definitions/776.html",
+		},
+		Action: ActionBlock,
+	}
+)
+
+// xxeRules() returns the built-in rules of the XXE detection
+func xxeRules() []*Rule {
+	return []*Rule{ruleXMLDoctype, ruleXMLExternalEntity, ruleXMLEntityBomb}
+}
+
+/*
+This function analyzes the DOCTYPE declarations of an XML body.
+
+@param request: Preprocessed request
+
+@return matches: Matches of the built-in XXE rules
+*/
+func detectXXE(request *dpipreprocessor.Request) (matches []Match) {
+	field := fieldDescription(dpipreprocessor.LocationXML, "")
+	for _, doctype := range request.Doctypes {
+		matches = append(matches, newMatch(ruleXMLDoctype, field, doctype, 0, len(doctype)))
+
+		entities := make(map[string]string)
+		for _, loc := range entityDeclaration.FindAllStringSubmatchIndex(doctype, -1) {
+			name := doctype[loc[4]:loc[5]]
+			if loc[6] >= 0 {
+				matches = append(matches, newMatch(ruleXMLExternalEntity, field, doctype, loc[0], loc[1]))
+				continue
+			}
+			value := ""
+			if loc[8] >= 0 {
+				value = doctype[loc[8]:loc[9]]
+			} else {
+				value = doctype[loc[10]:loc[11]]
+			}
+			entities[name] = value
+		}
+
+		expansion := &entityExpansion{entities: entities, sizes: make(map[string]int), active: make(map[string]bool)}
+		for name := range entities {
+			if expansion.size(name) > maxEntityExpansion {
+				matches = append(matches, newMatch(ruleXMLEntityBomb, field, name, 0, len(name)))
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// entityExpansion computes the sizes of the expanded entities of a DOCTYPE
+type entityExpansion struct {
+	entities map[string]string
+	sizes    map[string]int
+	active   map[string]bool // Entities, which are currently expanded, to detect recursive entities
+}
+
+// size() returns the length of the expanded entity. Recursive entities have an infinite size, which is represented
+// by a size above maxEntityExpansion.
+func (expansion *entityExpansion) size(name string) int {
+	if size, ok := expansion.sizes[name]; ok {
+		return size
+	}
+	if expansion.active[name] {
+		return maxEntityExpansion + 1
+	}
+	expansion.active[name] = true
+	defer delete(expansion.active, name)
+
+	value := expansion.entities[name]
+	size := len(value)
+	for _, reference := range entityReference.FindAllStringSubmatch(value, -1) {
+		if _, ok := expansion.entities[reference[1]]; !ok || strings.HasPrefix(reference[1], "#") {
+			continue
+		}
+		size += expansion.size(reference[1]) - len(reference[0])
+		if size > maxEntityExpansion {
+			break
+		}
+	}
+	expansion.sizes[name] = size
+	return size
+}
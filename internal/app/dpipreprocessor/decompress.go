@@ -0,0 +1,112 @@
+package dpipreprocessor
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+/*
+This file decompresses request bodies with a Content-Encoding, so the Detector inspects the content instead of the
+compressed bytes. The original bytes are still forwarded and kept as raw view of the body. The decompressed size and
+the compression ratio are limited to protect against decompression bombs: a body exceeding a limit is only inspected
+up to the limit and reported as anomaly.
+*/
+
+// Default limits of the decompression
+const (
+	DefaultDecompressionMaxSize  = 10 << 20
+	DefaultDecompressionMaxRatio = 100
+)
+
+// The ratio limit is only applied to bodies, which decompress to more bytes than this
+const minRatioCheckedSize = 64 << 10
+
+// newDecompressor() returns a reader, which decompresses the body according to a content coding
+func newDecompressor(coding string, body io.Reader) (io.Reader, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// "deflate" is defined as zlib format, but some clients send a raw deflate stream
+		buffered := bufio.NewReader(body)
+		header, _ := buffered.Peek(2)
+		if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	case "br":
+		return brotli.NewReader(body), nil
+	}
+	return nil, fmt.Errorf("unsupported content coding")
+}
+
+/*
+This method reverses all content codings of a body in the reverse order of their application. The decompressed body
+is stored in a spool, so it is spilled to a temporary file like the received body, when it exceeds the memory limit.
+A body, which was cut at the inspection limit, is decompressed up to the cut.
+
+@param data: Request, to which anomalies are reported
+@param body: Reader of the body, as it was received
+@param size: Size of the body, as it was received
+@param contentEncoding: Value of the Content-Encoding header
+
+@return decoded: Decompressed body; nil, when the body has no content coding or cannot be decompressed
+@return decodedSize: Number of bytes of the decompressed body, which should be inspected
+*/
+func (preprocessor *Preprocessor) decompress(data *Request, body io.Reader, size int64, contentEncoding string) (decoded *spool, decodedSize int64) {
+	codings := strings.Split(strings.ToLower(contentEncoding), ",")
+	reader, applied := body, []string{}
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.TrimSpace(codings[i])
+		if coding == "" || coding == "identity" {
+			continue
+		}
+
+		var err error
+		if reader, err = newDecompressor(coding, reader); err != nil {
+			data.addAnomaly(AnomalyInvalidContentEncoding, LocationBody, "", fmt.Sprintf("%s: %v", coding, err))
+			return nil, 0
+		}
+		applied = append(applied, coding)
+	}
+	if len(applied) == 0 {
+		return nil, 0
+	}
+	codingNames := strings.Join(applied, ", ")
+
+	// The limit is the smaller one of the maximum size and the maximum ratio
+	limit := size * int64(preprocessor.decompressionMaxRatio)
+	if limit < minRatioCheckedSize {
+		limit = minRatioCheckedSize
+	}
+	if limit > preprocessor.decompressionMaxSize {
+		limit = preprocessor.decompressionMaxSize
+	}
+
+	decoded = preprocessor.newSpool()
+	buffer := make([]byte, preprocessor.inspectionChunkSize)
+	decodedSize, err := io.CopyBuffer(decoded, io.LimitReader(reader, limit+1), buffer)
+	// A body cut at the inspection limit ends unexpectedly, so the content decoded up to the cut is inspected
+	if err == io.ErrUnexpectedEOF && data.truncated {
+		err, data.uninspected = nil, -1
+	}
+	if err != nil {
+		decoded.close()
+		data.addAnomaly(AnomalyInvalidContentEncoding, LocationBody, "", fmt.Sprintf("%s: %v", codingNames, err))
+		return nil, 0
+	}
+	if decodedSize > limit {
+		data.addAnomaly(AnomalyDecompressionLimit, LocationBody, "",
+			fmt.Sprintf("%s body of %d bytes decompresses to more than %d bytes", codingNames, size, limit))
+		decodedSize = limit
+		data.truncated = true
+	}
+	return decoded, decodedSize
+}
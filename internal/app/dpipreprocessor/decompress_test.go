@@ -0,0 +1,153 @@
+package dpipreprocessor
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+// compress() applies a content coding to the content
+func compress(t *testing.T, coding string, content []byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch coding {
+	case "gzip":
+		writer = gzip.NewWriter(&buffer)
+	case "deflate":
+		writer = zlib.NewWriter(&buffer)
+	case "raw-deflate":
+		writer, _ = flate.NewWriter(&buffer, flate.DefaultCompression)
+	case "br":
+		writer = brotli.NewWriter(&buffer)
+	default:
+		t.Fatalf("unknown content coding %s", coding)
+	}
+	writer.Write(content)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+// bodyValue() returns the normalized value of the body field of a request
+func bodyValue(data *Request) string {
+	for _, field := range data.Fields {
+		if field.Location == LocationBody {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+func TestDecompress(t *testing.T) {
+	content := []byte("id=1' union select password from users--")
+	tests := []struct {
+		name      string
+		encoding  string
+		body      []byte
+		value     string
+		anomalies []string
+	}{
+		{"gzip", "gzip", compress(t, "gzip", content), string(content), nil},
+		{"zlib deflate", "deflate", compress(t, "deflate", content), string(content), nil},
+		{"raw deflate", "deflate", compress(t, "raw-deflate", content), string(content), nil},
+		{"brotli", "br", compress(t, "br", content), string(content), nil},
+		{"gzip in brotli", "gzip, br", compress(t, "br", compress(t, "gzip", content)), string(content), nil},
+		{"identity", "identity", content, string(content), nil},
+		{"unsupported coding", "compress", content, string(content), []string{AnomalyInvalidContentEncoding}},
+		{"corrupt gzip", "gzip", []byte("\x1f\x8b\x08\x00garbage"), "\x1f\x8b\x08\x00garbage", []string{AnomalyInvalidContentEncoding}},
+		{"cut gzip", "gzip", compress(t, "gzip", content)[:20], "", []string{AnomalyInvalidContentEncoding}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := map[string]string{"Content-Encoding": test.encoding}
+			data := extract(t, config.BodyT{}, "POST", "/", headers, string(test.body))
+			if value := bodyValue(data); test.value != "" && value != test.value {
+				t.Errorf("body = %q, want %q", value, test.value)
+			}
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, test.anomalies) {
+				t.Errorf("anomalies = %q, want %q", types, test.anomalies)
+			}
+		})
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	bomb := compress(t, "gzip", make([]byte, 4<<20))
+	data := extract(t, config.BodyT{}, "POST", "/", map[string]string{"Content-Encoding": "gzip"}, string(bomb))
+	if types := anomalyTypes(data); !reflect.DeepEqual(types, []string{AnomalyDecompressionLimit}) {
+		t.Errorf("anomalies = %q, want %q", types, AnomalyDecompressionLimit)
+	}
+	if !data.truncated {
+		t.Error("body cut at the decompression limit is not marked as truncated")
+	}
+}
+
+// A compressed body, which exceeds the inspection limit, is inspected as decompressed content up to the cut
+func TestDecompressTruncated(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	content := make([]byte, 64<<10)
+	for i := range content {
+		content[i] = 'a' + byte(random.Intn(26))
+	}
+	for _, coding := range []string{"gzip", "deflate", "raw-deflate", "br"} {
+		t.Run(coding, func(t *testing.T) {
+			body := compress(t, coding, content)
+			encoding := coding
+			if coding == "raw-deflate" {
+				encoding = "deflate"
+			}
+			settings := config.BodyT{Inspection: config.InspectionT{MaxSize: int64(len(body) / 2)}}
+			data := extract(t, settings, "POST", "/", map[string]string{"Content-Encoding": encoding}, string(body))
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, []string{AnomalyBodyLimit}) {
+				t.Errorf("anomalies = %q, want only %q", types, AnomalyBodyLimit)
+			}
+			value := bodyValue(data)
+			if len(value) == 0 || !strings.HasPrefix(string(content), value) {
+				t.Errorf("body is not the decompressed content up to the cut (%d bytes)", len(value))
+			}
+		})
+	}
+}
+
+// The decompressed body is cut at the smaller one of the configured maximum size and ratio
+func TestDecompressConfiguredLimits(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	content := make([]byte, 256<<10)
+	for i := range content {
+		content[i] = 'a' + byte(random.Intn(2)) // Compresses to about an eighth
+	}
+	body := compress(t, "gzip", content)
+
+	tests := []struct {
+		name          string
+		decompression config.DecompressionT
+		size          int
+		anomalies     []string
+	}{
+		{"within the limits", config.DecompressionT{}, len(content), nil},
+		{"maximum size", config.DecompressionT{MaxSize: 100 << 10}, 100 << 10, []string{AnomalyDecompressionLimit}},
+		{"maximum ratio", config.DecompressionT{MaxRatio: 2}, 2 * len(body), []string{AnomalyDecompressionLimit}},
+		{"ratio of a small body", config.DecompressionT{MaxRatio: 1}, minRatioCheckedSize, []string{AnomalyDecompressionLimit}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := config.BodyT{Decompression: test.decompression}
+			data := extract(t, settings, "POST", "/", map[string]string{"Content-Encoding": "gzip"}, string(body))
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, test.anomalies) {
+				t.Errorf("anomalies = %q, want %q", types, test.anomalies)
+			}
+			if value := bodyValue(data); len(value) != test.size || !strings.HasPrefix(string(content), value) {
+				t.Errorf("body has %d bytes, want the first %d bytes of the content", len(value), test.size)
+			}
+		})
+	}
+}
@@ -2,11 +2,13 @@ package dpipreprocessor
 
 import (
 	"bytes"
-	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
 	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpilogger"
 )
 
@@ -16,87 +18,425 @@ HTTP-Request is extracted. In the Data Unifier, the extracted data is converted
 analysis.
 */
 
+// Locations of the fields of a request
+const (
+	LocationPath   = "path"
+	LocationQuery  = "query"
+	LocationHeader = "header"
+	LocationCookie = "cookie"
+	LocationBody   = "body"
+	LocationJSON   = "json" // Values of a JSON body (see json.go)
+	LocationForm   = "form" // Arguments of an URL-encoded or multipart form body
+	LocationFile   = "file" // File names of the uploads of a multipart body (see multipart.go)
+	LocationXML    = "xml"  // Element texts and attribute values of an XML body (see xml.go)
+	// Path and query of the request URL and all headers as single fields. They repeat other fields as a whole for the
+	// rules of other rule languages, which match strings spanning several arguments or a header with its name.
+	LocationRequestURI  = "request_uri"  // Path and raw query
+	LocationQueryString = "query_string" // Raw query
+	LocationHeaderLines = "header_lines" // Headers as lines "Name: value" separated by CRLF
+	// Canonical form of the path (see CanonicalPath()), which repeats the path with resolved dot segments
+	LocationCanonicalPath = "canonical_path"
+)
+
+// Field is a single part of a request, which is inspected by the Detector. Every field is kept in two views:
+// the raw name and value as they were received and the normalized name and value after decoding.
+type Field struct {
+	Location string
+	Name     string // Decoded name of a query argument, header or cookie in lower case; empty for the path and the body
+	Value    string // Decoded value; the case is preserved, so rules can choose their own case handling
+	RawName  string
+	RawValue string
+}
+
+// Views of a field, on which the transforms of a rule are applied
+const (
+	ViewNormalized = "normalized"
+	ViewRaw        = "raw"
+)
+
+// Types of protocol anomalies, which are found while preprocessing a request
+const (
+	AnomalyInvalidEncoding    = "invalid_encoding"    // A query or form argument contains an invalid percent-encoding
+	AnomalyMalformedJSON      = "malformed_json"      // A body with a JSON content type cannot be parsed
+	AnomalyJSONLimit          = "json_limit_exceeded" // A JSON body exceeds the maximum depth or number of elements
+	AnomalyMalformedMultipart = "malformed_multipart" // A multipart/form-data body cannot be parsed
+	AnomalyMalformedXML       = "malformed_xml"       // An XML body cannot be parsed
+	AnomalyXMLLimit           = "xml_limit_exceeded"  // An XML body exceeds the maximum depth or size
+	// The Content-Encoding of a body is not supported or the body cannot be decompressed
+	AnomalyInvalidContentEncoding = "invalid_content_encoding"
+	// A compressed body exceeds the maximum decompressed size or compression ratio
+	AnomalyDecompressionLimit = "decompression_limit_exceeded"
+	// A body exceeds the inspection limit and is only inspected partially or not at all (see body.go)
+	AnomalyBodyLimit = "body_limit_exceeded"
+)
+
+// Anomaly is a violation of the HTTP protocol, which is reported to the Detector
+type Anomaly struct {
+	Type     string
+	Location string
+	Name     string
+	Value    string // Raw value, which caused the anomaly, or a description of the violation
+}
+
+// Request is the unified representation of an HTTP request
+type Request struct {
+	Path          string // Decoded path of the request URL
+	CanonicalPath string // Canonical form of the decoded path (see CanonicalPath())
+	Fields        []Field
+	Anomalies     []Anomaly
+	Uploads       []Upload
+	Doctypes      []string // DOCTYPE declarations of an XML body
+	// The body exceeds the inspection limit with the policy "reject", so the request must be blocked independent of the
+	// enforcement mode, because its body was not inspected
+	BodyRejected bool
+
+	// Transformed names and values of the fields (see Transformed())
+	cache map[transformKey]string
+
+	// The inspected body was cut at the inspection or decompression limit, so parsers must not report its end as error
+	truncated bool
+	// Number of bytes of the received body after the inspection limit according to its Content-Length; -1, when unknown
+	uninspected int64
+	// Body, which was spilled to a temporary file and is inspected chunk by chunk (see ScanBody())
+	body      *spool
+	bodySize  int64
+	chunkSize int
+	// Spools, whose temporary files are removed by Close()
+	spools []*spool
+}
+
+type transformKey struct {
+	field      int
+	name       bool
+	view       string
+	transforms string
+}
+
 type Preprocessor struct {
 	dpiLogger *dpilogger.DPILogger
+
+	jsonMaxDepth    int
+	jsonMaxElements int
+	xmlMaxDepth     int
+	xmlMaxSize      int
+
+	decompressionMaxSize  int64
+	decompressionMaxRatio int
+
+	inspectionMaxSize        int64
+	inspectionChunkSize      int
+	inspectionMemoryLimit    int64
+	inspectionTempDir        string
+	inspectionExceededAction string
 }
 
-func New(_logDPI *dpilogger.DPILogger) *Preprocessor {
-	return &Preprocessor{dpiLogger: _logDPI}
+// New() creates a new Preprocessor, which parses bodies according to the given settings
+func New(_logDPI *dpilogger.DPILogger, body config.BodyT) *Preprocessor {
+	preprocessor := &Preprocessor{dpiLogger: _logDPI,
+		jsonMaxDepth:             body.JSON.MaxDepth,
+		jsonMaxElements:          body.JSON.MaxElements,
+		xmlMaxDepth:              body.XML.MaxDepth,
+		xmlMaxSize:               body.XML.MaxSize,
+		decompressionMaxSize:     body.Decompression.MaxSize,
+		decompressionMaxRatio:    body.Decompression.MaxRatio,
+		inspectionMaxSize:        body.Inspection.MaxSize,
+		inspectionChunkSize:      body.Inspection.ChunkSize,
+		inspectionMemoryLimit:    body.Inspection.MemoryLimit,
+		inspectionTempDir:        body.Inspection.TempDir,
+		inspectionExceededAction: body.Inspection.ExceededAction}
+	if preprocessor.jsonMaxDepth == 0 {
+		preprocessor.jsonMaxDepth = DefaultJSONMaxDepth
+	}
+	if preprocessor.jsonMaxElements == 0 {
+		preprocessor.jsonMaxElements = DefaultJSONMaxElements
+	}
+	if preprocessor.xmlMaxDepth == 0 {
+		preprocessor.xmlMaxDepth = DefaultXMLMaxDepth
+	}
+	if preprocessor.xmlMaxSize == 0 {
+		preprocessor.xmlMaxSize = DefaultXMLMaxSize
+	}
+	if preprocessor.decompressionMaxSize == 0 {
+		preprocessor.decompressionMaxSize = DefaultDecompressionMaxSize
+	}
+	if preprocessor.decompressionMaxRatio == 0 {
+		preprocessor.decompressionMaxRatio = DefaultDecompressionMaxRatio
+	}
+	if preprocessor.inspectionMaxSize == 0 {
+		preprocessor.inspectionMaxSize = DefaultInspectionMaxSize
+	}
+	if preprocessor.inspectionChunkSize == 0 {
+		preprocessor.inspectionChunkSize = DefaultInspectionChunkSize
+	}
+	if preprocessor.inspectionMemoryLimit == 0 {
+		preprocessor.inspectionMemoryLimit = DefaultInspectionMemoryLimit
+	}
+	if preprocessor.inspectionExceededAction == "" {
+		preprocessor.inspectionExceededAction = InspectionInspectPartial
+	}
+	return preprocessor
 }
 
 /*
-This method extracts the requested URL, header, cookies and body of the HTTP-request and converts them to a unified
-representation
+This method extracts the requested path, query arguments, headers, cookies and body of the HTTP-request and converts
+them to a unified representation
 
 @param request: Incoming request
 
-@return data: extracted data from the request
+@return data: extracted fields of the request
 */
-func (preprocessor *Preprocessor) ExtractConvertData(request *http.Request) (data []string) {
-	// Extract URL-Parameters, convert percent-encoded characters to the ascii-representation and convert inputs to lower case
-	reqURL, err := url.PathUnescape(request.URL.Path) // Extract URL-Path and convert URL-encoded characters to the ascii-representation
-	if err != nil {                                   // In case of an error, the unescaped URL-Path is used
-		reqURL = request.URL.Path
-		preprocessor.dpiLogger.Log("URL-Path decoding failed: " + reqURL)
-	}
-
-	query, err := url.QueryUnescape(request.URL.RawQuery) // Extract URL-Query and convert URL-encoded characters to the ascii-representation
-	if err != nil {                                       // In case of an error, the unescaped query is used
-		query = request.URL.RawQuery
-		preprocessor.dpiLogger.Log("URL-Query decoding failed: " + query)
-	}
-
-	fragment, err := url.QueryUnescape(request.URL.Fragment) // Extract URL-Fragment and convert URL-encoded characters to the ascii-representation
-	if err != nil {                                          // In case of an error, the unescaped fragment is used
-		fragment = request.URL.Fragment
-		preprocessor.dpiLogger.Log("URL-Fragment decoding failed: " + fragment)
-	}
-
-	urlData := strings.ToLower(reqURL + query + fragment) // Convert URL-parameters to lower case
-
-	// Extract all header data except cookies, convert URL-encoded parts to the ascii-representation and convert inputs to lower case
-	var headerData []string
-	for name, values := range request.Header { // Iterate over all HTTP headers of the request
-		if name != "Cookie" { // Cookies are treated separately below
-			for _, value := range values {
-				decData, err := url.QueryUnescape(value) // Convert URL-encoded characters to the ascii-representation
-				if err != nil {                          // In case of an error, the unescaped header is used
-					decData = value
-					preprocessor.dpiLogger.Log("Header decoding failed: " + decData)
-				}
-				headerData = append(headerData, strings.ToLower(decData)) // Convert inputs to lower case
+func (preprocessor *Preprocessor) ExtractConvertData(request *http.Request) (data *Request) {
+	data = &Request{}
+
+	// Extract URL-Path and convert percent-encoded characters to the ascii-representation. The request target of a
+	// server request has no fragment (see url.ParseRequestURI()), so a fragment sent by a client despite RFC 7230 remains
+	// part of the path or of the last query argument and is inspected there.
+	rawPath := request.URL.EscapedPath()
+	reqPath, err := url.PathUnescape(rawPath)
+	if err != nil { // In case of an error, the unescaped URL-Path is used
+		reqPath = rawPath
+	}
+	data.Path = reqPath
+	data.add(LocationPath, "", "", rawPath, reqPath)
+
+	// Keep the canonical path additionally, so rules and the log can refer to the resource requested in fact. The raw
+	// view is canonicalized without decoding.
+	data.CanonicalPath, _ = CanonicalPath(reqPath)
+	rawCanonicalPath, _ := CanonicalPath(rawPath)
+	data.add(LocationCanonicalPath, "", "", rawCanonicalPath, data.CanonicalPath)
+
+	// Extract all query arguments with their names. Names and values are decoded separately.
+	preprocessor.extractArguments(data, LocationQuery, request.URL.RawQuery)
+
+	// Keep path and query additionally as a whole
+	rawURI, uri := rawPath, reqPath
+	if request.URL.RawQuery != "" {
+		query, err := url.QueryUnescape(request.URL.RawQuery)
+		if err != nil { // In case of an error, the unescaped query is used
+			query = request.URL.RawQuery
+		}
+		data.add(LocationQueryString, "", "", request.URL.RawQuery, query)
+		rawURI, uri = rawURI+"?"+request.URL.RawQuery, uri+"?"+query
+	}
+	data.add(LocationRequestURI, "", "", rawURI, uri)
+
+	// Extract all header data except cookies and convert URL-encoded parts to the ascii-representation. Headers and
+	// cookies are not percent-encoded by definition, so a literal "%" is no anomaly.
+	var rawLines, lines []string
+	if request.Host != "" {
+		rawLines, lines = append(rawLines, "Host: "+request.Host), append(lines, "Host: "+request.Host)
+	}
+	for _, name := range sortedKeys(request.Header) { // Iterate over all HTTP headers of the request
+		for _, value := range request.Header[name] {
+			decData, err := url.QueryUnescape(value) // Convert URL-encoded characters to the ascii-representation
+			if err != nil {                          // In case of an error, the unescaped header is used
+				decData = value
+			}
+			rawLines, lines = append(rawLines, name+": "+value), append(lines, name+": "+decData)
+			if name != "Cookie" { // Cookies are treated separately below
+				data.add(LocationHeader, name, name, value, decData)
 			}
 		}
 	}
+	if len(lines) > 0 {
+		data.add(LocationHeaderLines, "", "", strings.Join(rawLines, "\r\n"), strings.Join(lines, "\r\n"))
+	}
 
-	// Extract all Cookies, convert percent-encoded parts to the ascii-representation and convert inputs to lower case
-	var cookies []string
+	// Extract all Cookies and convert percent-encoded parts to the ascii-representation
 	for _, c := range request.Cookies() { // Iterate over all cookies of the request
 		decCookie, err := url.QueryUnescape(c.Value) // Convert URL-encoded characters to the ascii-representation
 		if err != nil {                              // In case of an error, the unescaped cookie is used
 			decCookie = c.Value
-			preprocessor.dpiLogger.Log("Cookie decoding failed: " + decCookie)
 		}
-		cookies = append(cookies, strings.ToLower(decCookie)) // data converted to lower case
+		data.add(LocationCookie, c.Name, c.Name, c.Value, decCookie)
 	}
 
-	// Extract Body - URL-encoded characters in body are NOT decoded to be comparable to SNORT
-	body, err := ioutil.ReadAll(request.Body)
-	if err != nil {
-		preprocessor.dpiLogger.Log("Body could not be read")
+	// Extract Body - URL-encoded characters in the body field are NOT decoded to be comparable to SNORT
+	read, size := preprocessor.readBody(data, request)
+	if size > 0 {
+		// The body is forwarded as it was received, only the inspected content is decompressed
+		content, contentSize := read, size
+		if contentEncoding := request.Header.Get("Content-Encoding"); contentEncoding != "" {
+			if decoded, decodedSize := preprocessor.decompress(data, read.reader(size), size, contentEncoding); decoded != nil {
+				data.spools = append(data.spools, decoded)
+				content, contentSize = decoded, decodedSize
+			}
+		}
+		preprocessor.extractBody(data, request.Header.Get("Content-Type"), read, size, content, contentSize)
+	}
+
+	return data
+}
+
+/*
+This method adds the body to the request and parses structured bodies into their values. A body, which fits into the
+memory limit, becomes a single field. A body, which was spilled to a temporary file, is inspected chunk by chunk by the
+Detector instead (see ScanBody()). JSON and multipart bodies are parsed as stream up to the inspection limit, XML bodies
+up to their size limit and form bodies up to the memory limit.
+
+@param data: Request, to which the body is added
+@param contentType: Value of the Content-Type header
+@param read: Body, as it was received
+@param size: Number of bytes of the received body, which should be inspected
+@param content: Decompressed body; the received body, when it has no content coding
+@param contentSize: Number of bytes of the decompressed body, which should be inspected
+*/
+func (preprocessor *Preprocessor) extractBody(data *Request, contentType string, read *spool, size int64, content *spool, contentSize int64) {
+	if !read.spilled() && !content.spilled() {
+		data.add(LocationBody, "", "", string(read.bytes(size)), string(content.bytes(contentSize)))
+	} else {
+		data.body, data.bodySize, data.chunkSize = content, contentSize, preprocessor.inspectionChunkSize
 	}
-	bodyData := strings.ToLower(string(body)) // Convert body to lower case
-	if bodyData != "" {
-		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// Structured bodies are additionally parsed into their values
+	switch mediaType := mediaType(contentType); {
+	case isJSON(mediaType):
+		preprocessor.extractJSON(data, content.reader(contentSize))
+	case mediaType == "application/x-www-form-urlencoded":
+		body := content.prefix(contentSize, preprocessor.inspectionMemoryLimit+1)
+		preprocessor.extractArguments(data, LocationForm, formPrefix(body, preprocessor.inspectionMemoryLimit))
+	case mediaType == "multipart/form-data":
+		_, params, _ := mime.ParseMediaType(contentType)
+		preprocessor.extractMultipart(data, content.reader(contentSize), params["boundary"])
+	case isXML(mediaType, content.prefix(contentSize, sniffLength)):
+		preprocessor.extractXML(data, string(content.prefix(contentSize, int64(preprocessor.xmlMaxSize)+1)))
 	}
+}
 
-	// All extracted inputs from the request are listed in a slice
-	numAttr := len(headerData) + len(cookies) + 2
-	data = make([]string, numAttr)
-	data[0] = urlData
-	data[1] = bodyData
-	data = append(data, headerData...)
-	data = append(data, cookies...)
+// formPrefix() returns the complete arguments of a form body, which was cut at the memory limit
+func formPrefix(body []byte, memoryLimit int64) string {
+	if int64(len(body)) <= memoryLimit {
+		return string(body)
+	}
+	if i := bytes.LastIndexByte(body[:memoryLimit], '&'); i >= 0 {
+		return string(body[:i])
+	}
+	return ""
+}
 
-	return data
+/*
+This method splits a raw query or URL-encoded form body into its arguments first and decodes every name and value on
+its own afterwards. Therefore, a decoded "&" or "=" cannot be confused with a separator and an invalid
+percent-encoding only affects a single argument. A name or value, which cannot be decoded, is used in its raw form and
+reported as anomaly.
+
+@param data: Request, to which the arguments are added
+@param location: LocationQuery or LocationForm
+@param rawQuery: Query of the request URL or the form body
+*/
+func (preprocessor *Preprocessor) extractArguments(data *Request, location, rawQuery string) {
+	for _, arg := range strings.Split(rawQuery, "&") {
+		if arg == "" {
+			continue
+		}
+		rawName, rawValue := arg, ""
+		if i := strings.Index(arg, "="); i >= 0 {
+			rawName, rawValue = arg[:i], arg[i+1:]
+		}
+
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+			data.addAnomaly(AnomalyInvalidEncoding, location, rawName, rawName)
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			value = rawValue
+			data.addAnomaly(AnomalyInvalidEncoding, location, name, rawValue)
+		}
+		data.add(location, rawName, name, rawValue, value)
+	}
+}
+
+// add() appends a field to the request. Only the decoded name is converted to lower case, because names of
+// arguments, headers and cookies are selected case insensitively by the rules.
+func (data *Request) add(location, rawName, name, rawValue, value string) {
+	data.Fields = append(data.Fields, Field{
+		Location: location,
+		Name:     lowercase(name),
+		Value:    value,
+		RawName:  rawName,
+		RawValue: rawValue,
+	})
+}
+
+/*
+This method returns the value or the name of a field after the given transforms were applied. The results are cached,
+so every transformed value is computed only once per request, even if the transforms are only a prefix of the
+transforms of another rule.
+
+@param field: Index of the field in Fields
+@param name: True, when the name of the field should be transformed instead of its value
+@param view: ViewNormalized or ViewRaw
+@param names: Names of the transforms (see transforms.go), which are applied in the given order
+
+@return value: Transformed value
+*/
+func (data *Request) Transformed(field int, name bool, view string, names []string) string {
+	value := data.Fields[field].view(name, view)
+	if len(names) == 0 {
+		return value
+	}
+	if data.cache == nil {
+		data.cache = make(map[transformKey]string)
+	}
+
+	// Continue with the longest prefix of the transforms, which was already applied
+	key := transformKey{field: field, name: name, view: view}
+	start := 0
+	for i := len(names); i > 0; i-- {
+		key.transforms = strings.Join(names[:i], ",")
+		if cached, ok := data.cache[key]; ok {
+			value, start = cached, i
+			break
+		}
+	}
+	for i := start; i < len(names); i++ {
+		value = applyTransforms(names[i:i+1], value)
+		key.transforms = strings.Join(names[:i+1], ",")
+		data.cache[key] = value
+	}
+	return value
+}
+
+// view() returns the name or the value of the field in the given view
+func (field *Field) view(name bool, view string) string {
+	switch {
+	case name && view == ViewRaw:
+		return field.RawName
+	case name:
+		return field.Name
+	case view == ViewRaw:
+		return field.RawValue
+	}
+	return field.Value
+}
+
+// addAnomaly() reports a protocol anomaly of a field
+func (data *Request) addAnomaly(anomalyType, location, name, value string) {
+	data.Anomalies = append(data.Anomalies, Anomaly{
+		Type:     anomalyType,
+		Location: location,
+		Name:     strings.ToLower(name),
+		Value:    value,
+	})
+}
+
+// mediaType() returns the media type of a Content-Type header in lower case without its parameters
+func mediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// sortedKeys() returns the keys of a header or query map in a deterministic order
+func sortedKeys(values map[string][]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
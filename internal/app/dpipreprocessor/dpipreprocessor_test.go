@@ -0,0 +1,183 @@
+package dpipreprocessor
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+// extract() preprocesses a request with the given body settings and checks, that the body is forwarded completely
+func extract(t *testing.T, body config.BodyT, method, target string, headers map[string]string, content string) *Request {
+	t.Helper()
+	if body.Inspection.TempDir == "" {
+		body.Inspection.TempDir = t.TempDir()
+	}
+	request := httptest.NewRequest(method, target, strings.NewReader(content))
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	data := New(nil, body).ExtractConvertData(request)
+	t.Cleanup(data.Close)
+
+	forwarded, err := ioutil.ReadAll(request.Body)
+	if err != nil || string(forwarded) != content {
+		t.Fatalf("forwarded body differs from the received body (%d of %d bytes, %v)", len(forwarded), len(content), err)
+	}
+	return data
+}
+
+// anomalyTypes() returns the types of the anomalies of a request
+func anomalyTypes(data *Request) (types []string) {
+	for _, anomaly := range data.Anomalies {
+		types = append(types, anomaly.Type)
+	}
+	return types
+}
+
+// hasField() checks if the request has a field with the given location, name and value
+func hasField(data *Request, location, name, value string) bool {
+	for _, field := range data.Fields {
+		if field.Location == location && field.Name == name && field.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExtractArguments(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		fields    [][2]string // Name and value of the expected query fields
+		anomalies []Anomaly
+	}{
+		{"decoded separators", "/?a=b%26c%3Dd&e=%2e%2e", [][2]string{{"a", "b&c=d"}, {"e", ".."}}, nil},
+		{"plus and names", "/?User+Name=O%27Brien+x&flag", [][2]string{{"user name", "O'Brien x"}, {"flag", ""}}, nil},
+		{"invalid value", "/?a=100%&b=%zz1", [][2]string{{"a", "100%"}, {"b", "%zz1"}}, []Anomaly{
+			{AnomalyInvalidEncoding, LocationQuery, "a", "100%"}, {AnomalyInvalidEncoding, LocationQuery, "b", "%zz1"}}},
+		{"invalid name", "/?x%=1", [][2]string{{"x%", "1"}}, []Anomaly{{AnomalyInvalidEncoding, LocationQuery, "x%", "x%"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := extract(t, config.BodyT{}, "GET", test.target, nil, "")
+			var fields [][2]string
+			for _, field := range data.Fields {
+				if field.Location == LocationQuery {
+					fields = append(fields, [2]string{field.Name, field.Value})
+				}
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("fields = %q, want %q", fields, test.fields)
+			}
+			if !reflect.DeepEqual(data.Anomalies, test.anomalies) {
+				t.Errorf("anomalies = %+v, want %+v", data.Anomalies, test.anomalies)
+			}
+		})
+	}
+}
+
+// A fragment sent by a client is not split off the request target, but inspected as part of the path or the query
+func TestExtractFragment(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		location string
+		field    string
+		value    string
+	}{
+		{"after path", "/page#%3Cscript%3E", LocationPath, "", "/page#<script>"},
+		{"after query", "/page?a=1#%3Cscript%3E", LocationQuery, "a", "1#<script>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := extract(t, config.BodyT{}, "GET", test.target, nil, "")
+			if !hasField(data, test.location, test.field, test.value) {
+				t.Errorf("fields = %+v, want %s %q", data.Fields, test.location, test.value)
+			}
+		})
+	}
+}
+
+func TestExtractFormArguments(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		memoryLimit int64
+		fields      [][2]string // Name and value of the expected form fields
+		anomalies   []string
+	}{
+		{"decoded values", "application/x-www-form-urlencoded", "user=a%27b&pct=5%",
+			0, [][2]string{{"user", "a'b"}, {"pct", "5%"}}, []string{AnomalyInvalidEncoding}},
+		{"media type parameters", "Application/X-WWW-Form-Urlencoded; charset=UTF-8", "q=1+union+select",
+			0, [][2]string{{"q", "1 union select"}}, nil},
+		{"decoded separators", "application/x-www-form-urlencoded", "a=b%26c%3Dd&&Name+X=",
+			0, [][2]string{{"a", "b&c=d"}, {"name x", ""}}, nil},
+		{"repeated names", "application/x-www-form-urlencoded", "id=1&id=2",
+			0, [][2]string{{"id", "1"}, {"id", "2"}}, nil},
+		{"cut at the memory limit", "application/x-www-form-urlencoded", "a=1&b=2&c=" + strings.Repeat("3", 64),
+			16, [][2]string{{"a", "1"}, {"b", "2"}}, nil},
+		{"other media type", "text/plain", "a=1", 0, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := config.BodyT{}
+			body.Inspection.MemoryLimit = test.memoryLimit
+			data := extract(t, body, "POST", "/", map[string]string{"Content-Type": test.contentType}, test.body)
+			var fields [][2]string
+			for _, field := range data.Fields {
+				if field.Location == LocationForm {
+					fields = append(fields, [2]string{field.Name, field.Value})
+				}
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("fields = %q, want %q", fields, test.fields)
+			}
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, test.anomalies) {
+				t.Errorf("anomalies = %q, want %q", types, test.anomalies)
+			}
+		})
+	}
+}
+
+// Headers and cookies are not percent-encoded by definition, so a literal "%" is decoded as far as possible, but not
+// reported as anomaly
+func TestExtractHeadersAndCookies(t *testing.T) {
+	headers := map[string]string{"X-Progress": "100%", "X-Encoded": "a%20b", "Cookie": "discount=50%; id=%27x"}
+	data := extract(t, config.BodyT{}, "GET", "/", headers, "")
+	if len(data.Anomalies) > 0 {
+		t.Errorf("anomalies = %+v, want none", data.Anomalies)
+	}
+	for _, field := range [][3]string{{LocationHeader, "x-progress", "100%"}, {LocationHeader, "x-encoded", "a b"},
+		{LocationCookie, "discount", "50%"}, {LocationCookie, "id", "'x"}} {
+		if !hasField(data, field[0], field[1], field[2]) {
+			t.Errorf("missing %s field %s = %q", field[0], field[1], field[2])
+		}
+	}
+}
+
+// Every field keeps its raw name and value next to the normalized ones
+func TestExtractViews(t *testing.T) {
+	headers := map[string]string{"X-Encoded": "a%20B", "Cookie": "Session=%27X"}
+	data := extract(t, config.BodyT{}, "GET", "/a%2Fb?User%5B%5D=O%27Brien+X", headers, "")
+
+	want := []Field{
+		{LocationPath, "", "/a/b", "", "/a%2Fb"},
+		{LocationQuery, "user[]", "O'Brien X", "User%5B%5D", "O%27Brien+X"},
+		{LocationHeader, "x-encoded", "a B", "X-Encoded", "a%20B"},
+		{LocationCookie, "session", "'X", "Session", "%27X"},
+	}
+	for _, field := range want {
+		found := false
+		for _, got := range data.Fields {
+			found = found || got == field
+		}
+		if !found {
+			t.Errorf("missing field %+v in %+v", field, data.Fields)
+		}
+	}
+}
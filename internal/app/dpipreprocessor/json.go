@@ -0,0 +1,164 @@
+package dpipreprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+This file parses JSON request bodies into fields. Every scalar value of the document becomes a field, which is named
+by its flattened key path, e.g. "user.name" or "items[0].id". String values are decoded, so escapes like "\u0027" do
+not hide characters from the rules. The raw view of a field is the value as it is written in the document.
+*/
+
+// Default limits of the JSON parser
+const (
+	DefaultJSONMaxDepth    = 32
+	DefaultJSONMaxElements = 10000
+)
+
+// jsonFrame is an object or array of the JSON document, which is currently parsed
+type jsonFrame struct {
+	path      string
+	array     bool
+	index     int    // Index of the next element of an array
+	key       string // Key of the next value of an object
+	expectKey bool
+}
+
+// childPath() returns the path of the next value of the frame
+func (frame *jsonFrame) childPath() string {
+	if frame.array {
+		return frame.path + "[" + strconv.Itoa(frame.index) + "]"
+	}
+	if frame.path == "" {
+		return frame.key
+	}
+	return frame.path + "." + frame.key
+}
+
+// next() moves the frame on after one of its values was parsed
+func (frame *jsonFrame) next() {
+	if frame.array {
+		frame.index++
+	} else {
+		frame.expectKey = true
+	}
+}
+
+// isJSON() checks if the media type is application/json or a JSON based type like application/problem+json
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// jsonInput records the bytes, which the decoder reads from the body, until the raw text of a token was taken
+type jsonInput struct {
+	reader io.Reader
+	buffer []byte
+	offset int64 // Offset of the first byte of the buffer in the body
+}
+
+// Read() reads from the body and records the read bytes
+func (input *jsonInput) Read(p []byte) (n int, err error) {
+	n, err = input.reader.Read(p)
+	input.buffer = append(input.buffer, p[:n]...)
+	return n, err
+}
+
+// text() returns the bytes of the body between two offsets and discards the recorded bytes before the end
+func (input *jsonInput) text(start, end int64) string {
+	text := string(input.buffer[start-input.offset : end-input.offset])
+	input.buffer = append(input.buffer[:0], input.buffer[end-input.offset:]...)
+	input.offset = end
+	return text
+}
+
+/*
+This method adds the scalar values of a JSON body as fields to the request. The body is parsed as stream, so its size
+is only limited by the inspection limit. Malformed documents and documents, which exceed the configured depth or
+number of elements, are reported as anomaly. The values parsed until then are kept. A document, which was cut at the
+inspection or decompression limit, is parsed up to the cut without reporting its end.
+
+@param data: Request, to which the fields are added
+@param body: Reader of the body of the request
+*/
+func (preprocessor *Preprocessor) extractJSON(data *Request, body io.Reader) {
+	input := &jsonInput{reader: body}
+	decoder := json.NewDecoder(input)
+	decoder.UseNumber()
+
+	var stack []*jsonFrame
+	elements := 0
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF && len(stack) == 0 && elements > 0 {
+			return
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if !data.truncated {
+				data.addAnomaly(AnomalyMalformedJSON, LocationJSON, "", err.Error())
+			}
+			return
+		}
+		raw := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(input.text(start, decoder.InputOffset())), ":,"))
+
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top == nil && elements > 0 {
+			data.addAnomaly(AnomalyMalformedJSON, LocationJSON, "", fmt.Sprintf("unexpected data after the document at offset %d", start))
+			return
+		}
+
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				stack[len(stack)-1].next()
+			}
+			continue
+		}
+		if key, ok := token.(string); ok && top != nil && top.expectKey {
+			top.key, top.expectKey = key, false
+			continue
+		}
+
+		path := ""
+		if top != nil {
+			path = top.childPath()
+		}
+		elements++
+		if elements > preprocessor.jsonMaxElements {
+			data.addAnomaly(AnomalyJSONLimit, LocationJSON, path, fmt.Sprintf("more than %d elements", preprocessor.jsonMaxElements))
+			return
+		}
+
+		switch value := token.(type) {
+		case json.Delim: // Begin of an object or array
+			if len(stack) >= preprocessor.jsonMaxDepth {
+				data.addAnomaly(AnomalyJSONLimit, LocationJSON, path, fmt.Sprintf("more than %d nested levels", preprocessor.jsonMaxDepth))
+				return
+			}
+			stack = append(stack, &jsonFrame{path: path, array: value == '[', expectKey: value == '{'})
+			continue
+		case string:
+			data.add(LocationJSON, path, path, raw, value)
+		case json.Number:
+			data.add(LocationJSON, path, path, raw, value.String())
+		case bool:
+			data.add(LocationJSON, path, path, raw, strconv.FormatBool(value))
+		case nil:
+			data.add(LocationJSON, path, path, raw, "")
+		}
+		if top != nil {
+			top.next()
+		}
+	}
+}
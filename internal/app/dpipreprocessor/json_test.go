@@ -0,0 +1,123 @@
+package dpipreprocessor
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+var jsonHeaders = map[string]string{"Content-Type": "application/json"}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		fields    [][3]string // Name, raw value and value of the expected fields
+		anomalies []string
+	}{
+		{"object", `{"user": {"name": "alice", "age": 42}, "admin": false, "x": null}`,
+			[][3]string{{"user.name", `"alice"`, "alice"}, {"user.age", "42", "42"}, {"admin", "false", "false"}, {"x", "null", ""}}, nil},
+		{"array", `{"items": [{"id": 1}, "a'b"]}`,
+			[][3]string{{"items[0].id", "1", "1"}, {"items[1]", `"a'b"`, "a'b"}}, nil},
+		{"scalar document", `"text"`, [][3]string{{"", `"text"`, "text"}}, nil},
+		{"unclosed object", `{"a": "b"`, [][3]string{{"a", `"b"`, "b"}}, []string{AnomalyMalformedJSON}},
+		{"data after the document", `{"a": 1} {"b": 2}`, [][3]string{{"a", "1", "1"}}, []string{AnomalyMalformedJSON}},
+		{"invalid token", `{"a": tru}`, nil, []string{AnomalyMalformedJSON}},
+		{"too deep", strings.Repeat("[", 33) + strings.Repeat("]", 33), nil, []string{AnomalyJSONLimit}},
+		{"unicode escapes", `{"q": "\u0027 or 1=1\u002d\u002d", "e": "\ud83d\ude00"}`,
+			[][3]string{{"q", `"\u0027 or 1=1\u002d\u002d"`, "' or 1=1--"}, {"e", `"\ud83d\ude00"`, "😀"}}, nil},
+		{"escaped key", `{"a\"b": 1, "c\u002ed": 2}`, [][3]string{{`a"b`, "1", "1"}, {"c.d", "2", "2"}}, nil},
+		{"invalid UTF-8", "{\"a\": \"\xc0\xaf\"}", [][3]string{{"a", "\"\xc0\xaf\"", "\ufffd\ufffd"}}, nil},
+		{"invalid escape", `{"a": "\x41"}`, nil, []string{AnomalyMalformedJSON}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := extract(t, config.BodyT{}, "POST", "/", jsonHeaders, test.body)
+			var fields [][3]string
+			for _, field := range data.Fields {
+				if field.Location == LocationJSON {
+					fields = append(fields, [3]string{field.Name, field.RawValue, field.Value})
+				}
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("fields = %q, want %q", fields, test.fields)
+			}
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, test.anomalies) {
+				t.Errorf("anomalies = %q, want %q", types, test.anomalies)
+			}
+		})
+	}
+}
+
+func TestExtractJSONLimits(t *testing.T) {
+	settings := config.BodyT{JSON: config.JSONT{MaxElements: 3, MaxDepth: 2}}
+	tests := []struct {
+		name      string
+		body      string
+		anomalies []string
+	}{
+		{"within the limits", `{"a": [1]}`, nil},
+		{"too many elements", `[1, 2, 3, 4]`, []string{AnomalyJSONLimit}},
+		{"too deep", `{"a": {"b": {"c": 1}}}`, []string{AnomalyJSONLimit}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := extract(t, settings, "POST", "/", jsonHeaders, test.body)
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, test.anomalies) {
+				t.Errorf("anomalies = %q, want %q", types, test.anomalies)
+			}
+		})
+	}
+}
+
+func TestIsJSON(t *testing.T) {
+	for mediaType, want := range map[string]bool{
+		"application/json": true, "application/vnd.api+json": true, "application/problem+json": true,
+		"text/json": false, "application/x-www-form-urlencoded": false, "application/jsonp": false,
+	} {
+		if isJSON(mediaType) != want {
+			t.Errorf("isJSON(%q) = %t, want %t", mediaType, !want, want)
+		}
+	}
+}
+
+// A well-formed body beyond the memory limit is parsed completely from the temporary file without any anomaly
+func TestExtractJSONBeyondMemoryLimit(t *testing.T) {
+	var body strings.Builder
+	body.WriteString(`{"items": [`)
+	for i := 0; body.Len() < 3<<20; i++ {
+		fmt.Fprintf(&body, `{"id": %d, "text": "%s"},`, i, strings.Repeat("x", 1000))
+	}
+	body.WriteString(`{"last": "' or 1=1--"}]}`)
+
+	data := extract(t, config.BodyT{JSON: config.JSONT{MaxElements: 100000}}, "POST", "/", jsonHeaders, body.String())
+	if len(data.Anomalies) > 0 {
+		t.Errorf("anomalies = %q, want none", anomalyTypes(data))
+	}
+	if data.body == nil {
+		t.Error("body was not spilled to a temporary file")
+	}
+	last := false
+	for _, field := range data.Fields {
+		last = last || strings.HasSuffix(field.Name, ".last") && field.Value == "' or 1=1--"
+	}
+	if !last {
+		t.Error("last value of the body was not extracted")
+	}
+}
+
+// A body cut at the inspection limit is parsed up to the cut without reporting a malformed document
+func TestExtractJSONTruncated(t *testing.T) {
+	body := `{"a": "` + strings.Repeat("x", 100) + `", "b": "` + strings.Repeat("y", 100) + `"}`
+	settings := config.BodyT{Inspection: config.InspectionT{MaxSize: 150}}
+	data := extract(t, settings, "POST", "/", jsonHeaders, body)
+	if !hasField(data, LocationJSON, "a", strings.Repeat("x", 100)) {
+		t.Error("value before the cut was not extracted")
+	}
+	if types := anomalyTypes(data); !reflect.DeepEqual(types, []string{AnomalyBodyLimit}) {
+		t.Errorf("anomalies = %q, want only %q", types, AnomalyBodyLimit)
+	}
+}
@@ -0,0 +1,135 @@
+package dpipreprocessor
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+/*
+This file parses multipart/form-data bodies. Form fields become arguments like the fields of an URL-encoded form.
+File parts are described by an Upload, which the Detector checks against the upload policy of the requested route.
+The file name of every upload is additionally added as field, so rules can inspect it. A body, which was cut at the
+inspection limit, is parsed up to the cut. The size of the file, in which the body was cut, is completed with the
+uninspected bytes of the body according to its Content-Length, so the size limits of uploads apply beyond the
+inspection limit.
+*/
+
+// Number of bytes of a file, which are used to detect its content type (see http.DetectContentType())
+const sniffLength = 512
+
+// Upload is a file part of a multipart/form-data body
+type Upload struct {
+	Name         string // Name of the form field
+	Filename     string // File name as sent by the client, including any directories
+	ContentType  string // Declared media type of the part
+	DetectedType string // Media type detected from the magic bytes of the content
+	Size         int64
+	// The body was cut in this file. Its size includes the uninspected bytes of the body, when they are known, and may
+	// therefore include the following parts, too.
+	Truncated bool
+}
+
+// multipartInput notices the end of the body, so the unexpected end of a truncated body is not reported as anomaly
+type multipartInput struct {
+	reader io.Reader
+	eof    bool
+}
+
+// Read() reads from the body and notices its end
+func (input *multipartInput) Read(p []byte) (n int, err error) {
+	n, err = input.reader.Read(p)
+	input.eof = input.eof || err == io.EOF
+	return n, err
+}
+
+/*
+This method adds the form fields and the uploads of a multipart/form-data body to the request. A body, which cannot be
+parsed, is reported as anomaly. The parts parsed until then are kept.
+
+@param data: Request, to which the fields and uploads are added
+@param body: Reader of the body of the request
+@param boundary: Boundary parameter of the Content-Type header
+*/
+func (preprocessor *Preprocessor) extractMultipart(data *Request, body io.Reader, boundary string) {
+	if boundary == "" {
+		data.addAnomaly(AnomalyMalformedMultipart, LocationForm, "", "missing boundary")
+		return
+	}
+
+	input := &multipartInput{reader: body}
+	// An error at the end of a truncated body is caused by the cut
+	cut := func() bool {
+		return data.truncated && input.eof
+	}
+
+	reader := multipart.NewReader(input, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF || err != nil && cut() {
+			return
+		}
+		if err != nil {
+			data.addAnomaly(AnomalyMalformedMultipart, LocationForm, "", err.Error())
+			return
+		}
+
+		// Part.FileName() removes the directories of the file name, which have to be inspected, too
+		_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		name, filename := params["name"], params["filename"]
+
+		if _, isFile := params["filename"]; !isFile {
+			var value bytes.Buffer
+			_, err := io.Copy(&value, part)
+			if err != nil && !cut() {
+				data.addAnomaly(AnomalyMalformedMultipart, LocationForm, name, err.Error())
+				return
+			}
+			data.add(LocationForm, name, name, value.String(), value.String())
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		// Only the beginning of a file is kept to detect its type, the rest is only counted
+		head := make([]byte, sniffLength)
+		n, err := io.ReadFull(part, head)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		var rest int64
+		if err == nil {
+			rest, err = io.Copy(io.Discard, part)
+		}
+		if err != nil && !cut() {
+			data.addAnomaly(AnomalyMalformedMultipart, LocationForm, name, err.Error())
+			return
+		}
+
+		upload := Upload{
+			Name:        strings.ToLower(name),
+			Filename:    filename,
+			ContentType: mediaType(part.Header.Get("Content-Type")),
+			Size:        int64(n) + rest,
+		}
+		if n > 0 {
+			upload.DetectedType = mediaType(http.DetectContentType(head[:n]))
+		}
+		if err != nil {
+			// Only the closing delimiter of the body follows the file, when it is the last part
+			upload.Truncated = true
+			if uninspected := data.uninspected - int64(len("\r\n--"+boundary+"--\r\n")); uninspected > 0 {
+				upload.Size += uninspected
+			}
+		}
+		data.Uploads = append(data.Uploads, upload)
+		data.add(LocationFile, name, name, filename, filename)
+		if err != nil {
+			return
+		}
+	}
+}
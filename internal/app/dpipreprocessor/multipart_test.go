@@ -0,0 +1,128 @@
+package dpipreprocessor
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/textproto"
+	"reflect"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+// Beginning of a PNG image, which is recognized by http.DetectContentType()
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+
+// multipartPart is a form field or, with a file name, a file of a multipart body
+type multipartPart struct {
+	name, filename, contentType string
+	content                     []byte
+}
+
+// multipartBody() builds a multipart/form-data body and returns it with its Content-Type
+func multipartBody(t *testing.T, parts []multipartPart) (string, map[string]string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		if part.filename != "" {
+			header.Set("Content-Disposition", `form-data; name="`+part.name+`"; filename="`+part.filename+`"`)
+			header.Set("Content-Type", part.contentType)
+		} else {
+			header.Set("Content-Disposition", `form-data; name="`+part.name+`"`)
+		}
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(part.content)
+	}
+	writer.Close()
+	return body.String(), map[string]string{"Content-Type": writer.FormDataContentType()}
+}
+
+func TestExtractMultipart(t *testing.T) {
+	body, headers := multipartBody(t, []multipartPart{
+		{name: "title", content: []byte("<script>alert(1)</script>")},
+		{name: "Image", filename: "../../avatar.png", contentType: "image/png", content: append(pngHeader, make([]byte, 1000)...)},
+		{name: "doc", filename: "a.pdf", contentType: "application/pdf", content: []byte("text")},
+	})
+	data := extract(t, config.BodyT{}, "POST", "/upload", headers, body)
+
+	if len(data.Anomalies) > 0 {
+		t.Errorf("anomalies = %q, want none", anomalyTypes(data))
+	}
+	if !hasField(data, LocationForm, "title", "<script>alert(1)</script>") {
+		t.Error("form field was not extracted")
+	}
+	if !hasField(data, LocationFile, "image", "../../avatar.png") {
+		t.Error("file name with directories was not extracted")
+	}
+	want := []Upload{
+		{Name: "image", Filename: "../../avatar.png", ContentType: "image/png", DetectedType: "image/png", Size: int64(len(pngHeader) + 1000)},
+		{Name: "doc", Filename: "a.pdf", ContentType: "application/pdf", DetectedType: "text/plain", Size: 4},
+	}
+	if !reflect.DeepEqual(data.Uploads, want) {
+		t.Errorf("uploads = %+v, want %+v", data.Uploads, want)
+	}
+}
+
+func TestExtractMultipartMalformed(t *testing.T) {
+	body, headers := multipartBody(t, []multipartPart{{name: "a", content: []byte("b")}})
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"missing boundary", "multipart/form-data", body},
+		{"missing closing delimiter", headers["Content-Type"], body[:len(body)-10]},
+		{"wrong boundary", "multipart/form-data; boundary=other", body},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := extract(t, config.BodyT{}, "POST", "/", map[string]string{"Content-Type": test.contentType}, test.body)
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, []string{AnomalyMalformedMultipart}) {
+				t.Errorf("anomalies = %q, want %q", types, AnomalyMalformedMultipart)
+			}
+		})
+	}
+}
+
+// A body cut at the inspection limit is parsed up to the cut. The size of the cut file is completed with the
+// uninspected bytes according to the Content-Length.
+func TestExtractMultipartTruncated(t *testing.T) {
+	image := append(append([]byte{}, pngHeader...), make([]byte, 9<<20)...)
+	tests := []struct {
+		name    string
+		parts   []multipartPart
+		maxSize int64
+		uploads int
+	}{
+		{"cut in the file", []multipartPart{{name: "a", content: []byte("b")},
+			{name: "image", filename: "a.png", contentType: "image/png", content: image}}, 0, 1},
+		{"cut in the head of the file", []multipartPart{
+			{name: "image", filename: "a.png", contentType: "image/png", content: image}}, 200, 1},
+		{"cut in a form field", []multipartPart{{name: "a", content: make([]byte, 1000)}}, 500, 0},
+		{"cut between parts", []multipartPart{{name: "a", content: []byte("b")},
+			{name: "image", filename: "a.png", contentType: "image/png", content: image}}, 120, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, headers := multipartBody(t, test.parts)
+			settings := config.BodyT{Inspection: config.InspectionT{MaxSize: test.maxSize}}
+			data := extract(t, settings, "POST", "/upload", headers, body)
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, []string{AnomalyBodyLimit}) {
+				t.Errorf("anomalies = %q, want only %q", types, AnomalyBodyLimit)
+			}
+			if len(data.Uploads) != test.uploads {
+				t.Fatalf("%d uploads, want %d", len(data.Uploads), test.uploads)
+			}
+			for _, upload := range data.Uploads {
+				if !upload.Truncated || upload.Size != int64(len(image)) {
+					t.Errorf("upload = %+v, want truncated upload of %d bytes", upload, len(image))
+				}
+			}
+		})
+	}
+}
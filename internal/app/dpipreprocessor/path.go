@@ -0,0 +1,68 @@
+package dpipreprocessor
+
+import (
+	"strings"
+)
+
+/*
+This file canonicalizes paths, so that equivalent notations of a path like "/a/./b//c", "/a\b\c" or "/a/x/..;/b/c"
+are compared in the same form.
+*/
+
+/*
+This function converts a path into its canonical form. Backslashes are converted to slashes, duplicate slashes are
+collapsed, path parameters (";jsessionid=...") are stripped and the dot segments "." and ".." are resolved. A leading
+drive letter ("c:") is treated like the root directory.
+
+@param value: Path, which should be canonicalized
+
+@return canonical: Canonical form of the path
+@return escapes: True, when a ".." segment refers to the parent of the root directory or, for a relative path, to
+the parent of the current directory
+*/
+func CanonicalPath(value string) (canonical string, escapes bool) {
+	value = strings.ReplaceAll(value, "\\", "/")
+
+	drive := ""
+	if len(value) >= 2 && value[1] == ':' && isLetter(value[0]) {
+		drive, value = value[:2], value[2:]
+	}
+	absolute := drive != "" || strings.HasPrefix(value, "/")
+
+	var segments []string
+	for _, segment := range strings.Split(value, "/") {
+		if i := strings.IndexByte(segment, ';'); i >= 0 {
+			segment = segment[:i]
+		}
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) == 0 {
+				escapes = true
+				continue
+			}
+			segments = segments[:len(segments)-1]
+		default:
+			segments = append(segments, segment)
+		}
+	}
+
+	canonical = strings.Join(segments, "/")
+	if absolute {
+		canonical = drive + "/" + canonical
+	}
+	if len(segments) > 0 && strings.HasSuffix(value, "/") {
+		canonical += "/"
+	}
+	return canonical, escapes
+}
+
+// normalizePath() is the transform of CanonicalPath()
+func normalizePath(value string) string {
+	canonical, _ := CanonicalPath(value)
+	return canonical
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
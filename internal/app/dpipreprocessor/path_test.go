@@ -0,0 +1,64 @@
+package dpipreprocessor
+
+import (
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		value     string
+		canonical string
+		escapes   bool
+	}{
+		{"/a/b/../c", "/a/c", false},
+		{"/a/./b//c/", "/a/b/c/", false},
+		{"/../etc/passwd", "/etc/passwd", true},
+		{"a/../../b", "b", true},
+		{"../../../../etc/passwd", "etc/passwd", true},
+		{"images/../index.html", "index.html", false},
+		{"\\..\\windows\\win.ini", "/windows/win.ini", true},
+		{"c:\\windows\\..\\..\\boot.ini", "c:/boot.ini", true},
+		{"C:/Windows/./System32", "C:/Windows/System32", false},
+		{"/a/..;/b;jsessionid=1/c", "/b/c", false},
+		{"/..;/admin", "/admin", true},
+		{"/", "/", false},
+		{"", "", false},
+		{"....//file", "..../file", false}, // "...." is an ordinary file name
+		{"a:b", "a:/b", false},             // Leading drive letter
+	}
+	for _, test := range tests {
+		canonical, escapes := CanonicalPath(test.value)
+		if canonical != test.canonical || escapes != test.escapes {
+			t.Errorf("CanonicalPath(%q) = %q, %t, want %q, %t", test.value, canonical, escapes, test.canonical,
+				test.escapes)
+		}
+	}
+}
+
+func TestExtractCanonicalPath(t *testing.T) {
+	tests := []struct {
+		target    string
+		canonical string
+		raw       string
+	}{
+		{"/public/..;/admin/", "/admin/", "/admin/"},
+		{"/a/%2e%2e/b//c", "/b/c", "/a/%2e%2e/b/c"},
+		{"/a/b%5c..%5cc?x=1", "/a/c", "/a/b%5c..%5cc"},
+		{"/", "/", "/"},
+	}
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			data := extract(t, config.BodyT{}, "GET", test.target, nil, "")
+			if data.CanonicalPath != test.canonical || !hasField(data, LocationCanonicalPath, "", test.canonical) {
+				t.Errorf("canonical path = %q, fields = %+v, want %q", data.CanonicalPath, data.Fields, test.canonical)
+			}
+			for _, field := range data.Fields {
+				if field.Location == LocationCanonicalPath && field.RawValue != test.raw {
+					t.Errorf("raw canonical path = %q, want %q", field.RawValue, test.raw)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package dpipreprocessor
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+This file implements the normalization pipeline of the DPI. Every rule declares the transforms it needs, which are
+applied in the given order to the preprocessed values of a request before the rule is matched. The transformed values
+are cached per request (see Request.Transformed()), so a value is transformed only once for all rules, which share
+the same transforms or a prefix of them.
+*/
+
+// Transforms, which can be declared by the rules
+var transforms = map[string]func(string) string{
+	"lowercase":          lowercase,
+	"lowercaseASCII":     LowercaseASCII,
+	"urlDecode":          urlDecode,
+	"unicodeDecode":      unicodeDecode,
+	"htmlEntityDecode":   htmlEntityDecode,
+	"removeNulls":        removeNulls,
+	"compressWhitespace": compressWhitespace,
+	"removeComments":     removeComments,
+	"replaceComments":    replaceComments,
+	"normalizePath":      normalizePath,
+	"removeShellEscapes": removeShellEscapes,
+}
+
+// Maximum number of decoding rounds of urlDecode. Values, which are encoded more often, are suspicious on their own.
+const maxURLDecodeRounds = 5
+
+// IsTransform() checks if a transform with the given name exists
+func IsTransform(name string) bool {
+	_, ok := transforms[name]
+	return ok
+}
+
+// applyTransforms() applies the named transforms in the given order to the value
+func applyTransforms(names []string, value string) string {
+	for _, name := range names {
+		value = transforms[name](value)
+	}
+	return value
+}
+
+// lowercase() converts all characters to lower case. In contrast to strings.ToLower() invalid UTF-8 bytes are kept,
+// so that overlong sequences can still be decoded afterwards.
+func lowercase(value string) string {
+	var builder strings.Builder
+	builder.Grow(len(value))
+	for i := 0; i < len(value); {
+		c := value[i]
+		if c < utf8.RuneSelf {
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			builder.WriteByte(c)
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(value[i:])
+		if r == utf8.RuneError && size == 1 {
+			builder.WriteByte(c)
+		} else {
+			builder.WriteRune(unicode.ToLower(r))
+		}
+		i += size
+	}
+	return builder.String()
+}
+
+// LowercaseASCII() converts only the ASCII letters to lower case and keeps all other bytes, like the case insensitive
+// matching of Snort and ModSecurity, which compares bytes instead of characters
+func LowercaseASCII(value string) string {
+	for i := 0; i < len(value); i++ {
+		if 'A' <= value[i] && value[i] <= 'Z' {
+			bytes := []byte(value)
+			for j := i; j < len(bytes); j++ {
+				if 'A' <= bytes[j] && bytes[j] <= 'Z' {
+					bytes[j] += 'a' - 'A'
+				}
+			}
+			return string(bytes)
+		}
+	}
+	return value
+}
+
+// urlDecode() decodes percent-encoded characters repeatedly until the value is stable, so that multiple encoded
+// payloads like "%252e%252e%252f" are decoded completely. Invalid escapes are kept unchanged.
+func urlDecode(value string) string {
+	for round := 0; round < maxURLDecodeRounds; round++ {
+		decoded := percentDecode(value)
+		if decoded == value {
+			break
+		}
+		value = decoded
+	}
+	return value
+}
+
+// percentDecode() decodes all valid percent-encoded bytes and "+" once
+func percentDecode(value string) string {
+	if !strings.ContainsAny(value, "%+") {
+		return value
+	}
+	var builder strings.Builder
+	builder.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '%' && i+2 < len(value) && isHex(value[i+1]) && isHex(value[i+2]):
+			builder.WriteByte(unhex(value[i+1])<<4 | unhex(value[i+2]))
+			i += 2
+		case value[i] == '+':
+			builder.WriteByte(' ')
+		default:
+			builder.WriteByte(value[i])
+		}
+	}
+	return builder.String()
+}
+
+/*
+This function decodes unicode escapes and overlong UTF-8 sequences:
+
+	%uXXXX and \uXXXX (IIS and JavaScript style escapes)
+	overlong UTF-8 sequences of ASCII characters, e.g. the bytes C0 AE for "."
+
+@param value: Value, which should be decoded
+
+@return decoded: Value with all escapes and overlong sequences replaced by their characters
+*/
+func unicodeDecode(value string) string {
+	var builder strings.Builder
+	builder.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case (c == '%' || c == '\\') && i+5 < len(value) && (value[i+1] == 'u' || value[i+1] == 'U') &&
+			isHex(value[i+2]) && isHex(value[i+3]) && isHex(value[i+4]) && isHex(value[i+5]):
+			r := rune(unhex(value[i+2]))<<12 | rune(unhex(value[i+3]))<<8 | rune(unhex(value[i+4]))<<4 | rune(unhex(value[i+5]))
+			builder.WriteRune(fullWidthToASCII(r))
+			i += 5
+		case (c == 0xc0 || c == 0xc1) && i+1 < len(value) && value[i+1]&0xc0 == 0x80:
+			// Overlong two byte sequence of an ASCII character
+			builder.WriteByte((c&0x1f)<<6 | value[i+1]&0x3f)
+			i++
+		case c == 0xe0 && i+2 < len(value) && value[i+1]&0xe0 == 0x80 && value[i+2]&0xc0 == 0x80:
+			// Overlong three byte sequence of a character below U+0800
+			builder.WriteRune(rune(value[i+1]&0x3f)<<6 | rune(value[i+2]&0x3f))
+			i += 2
+		case c >= utf8.RuneSelf:
+			r, size := utf8.DecodeRuneInString(value[i:])
+			if r == utf8.RuneError {
+				builder.WriteByte(c)
+				continue
+			}
+			builder.WriteRune(fullWidthToASCII(r))
+			i += size - 1
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+// fullWidthToASCII() maps the full width forms of ASCII characters (U+FF01 to U+FF5E) to ASCII
+func fullWidthToASCII(r rune) rune {
+	if r >= 0xff01 && r <= 0xff5e {
+		return r - 0xff01 + '!'
+	}
+	return r
+}
+
+// htmlEntityDecode() decodes named and numeric HTML entities, e.g. "&lt;", "&#39;" and "&#x27;"
+func htmlEntityDecode(value string) string {
+	if !strings.Contains(value, "&") {
+		return value
+	}
+	return html.UnescapeString(value)
+}
+
+func removeNulls(value string) string {
+	return strings.ReplaceAll(value, "\x00", "")
+}
+
+// compressWhitespace() replaces every sequence of whitespace characters with a single space
+func compressWhitespace(value string) string {
+	var builder strings.Builder
+	builder.Grow(len(value))
+	space := false
+	for _, r := range value {
+		if unicode.IsSpace(r) {
+			if !space {
+				builder.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// removeComments() removes SQL comments ("/* */", "--" and "#" up to the end of the line)
+func removeComments(value string) string {
+	if !strings.Contains(value, "/*") && !strings.Contains(value, "--") && !strings.Contains(value, "#") {
+		return value
+	}
+	return stripComments(value, "", true)
+}
+
+// replaceComments() replaces C-style comments ("/* */") with a single space, so "union/**/select" becomes
+// "union select". Line comments are kept, because they are part of many SQL injection signatures.
+func replaceComments(value string) string {
+	if !strings.Contains(value, "/*") && !strings.Contains(value, "*/") {
+		return value
+	}
+	return stripComments(value, " ", false)
+}
+
+func stripComments(value, replacement string, lineComments bool) string {
+	var builder strings.Builder
+	builder.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		switch {
+		case strings.HasPrefix(value[i:], "/*!"):
+			// MySQL executes the content of "/*! */" comments, so only the comment markers are replaced
+			builder.WriteString(replacement)
+			i += 2
+		case strings.HasPrefix(value[i:], "/*"):
+			builder.WriteString(replacement)
+			end := strings.Index(value[i+2:], "*/")
+			if end < 0 {
+				return builder.String()
+			}
+			i += end + 3
+		case strings.HasPrefix(value[i:], "*/"):
+			builder.WriteString(replacement)
+			i++
+		case lineComments && (strings.HasPrefix(value[i:], "--") || value[i] == '#'):
+			builder.WriteString(replacement)
+			end := strings.IndexByte(value[i:], '\n')
+			if end < 0 {
+				return builder.String()
+			}
+			i += end - 1
+		default:
+			builder.WriteByte(value[i])
+		}
+	}
+	return builder.String()
+}
+
+// removeShellEscapes() removes the characters, with which a shell command can be split without changing it: quotes
+// ("c"a"t"), backslashes ("c\at"), carets of cmd.exe ("c^at") and empty positional parameters ("c$@at"). The field
+// separator variable ("cat${IFS}/etc/passwd") is replaced with a space.
+func removeShellEscapes(value string) string {
+	if !strings.ContainsAny(value, "'\"\\^$") {
+		return value
+	}
+	var builder strings.Builder
+	builder.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\'' || c == '"' || c == '\\' || c == '^':
+			// Removed
+		case c == '$' && ifsLength(value[i:]) > 0:
+			builder.WriteByte(' ')
+			i += ifsLength(value[i:]) - 1
+		case c == '$' && i+1 < len(value) && (value[i+1] == '@' || value[i+1] == '*' || '0' <= value[i+1] && value[i+1] <= '9'):
+			i++
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+// ifsLength() returns the length of a reference to the field separator variable at the beginning of the value, e.g.
+// "$IFS", "${IFS}" or "${IFS%??}"; 0, when the value does not start with such a reference
+func ifsLength(value string) int {
+	if strings.HasPrefix(value, "${IFS") {
+		if end := strings.IndexByte(value, '}'); end > 0 {
+			return end + 1
+		}
+	}
+	if strings.HasPrefix(value, "$IFS") && (len(value) == 4 || !isWordChar(value[4])) {
+		return 4
+	}
+	return 0
+}
+
+func isWordChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
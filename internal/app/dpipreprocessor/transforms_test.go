@@ -0,0 +1,98 @@
+package dpipreprocessor
+
+import "testing"
+
+func TestLowercase(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform string
+		value     string
+		want      string
+	}{
+		{"ascii", "lowercase", "SELECT * FROM", "select * from"},
+		{"unicode", "lowercase", "ÄÖÜ", "äöü"},
+		{"overlong sequence", "lowercase", "\xc0\xaf/ETC", "\xc0\xaf/etc"},
+		{"ascii only", "lowercaseASCII", "SELECT ÄÖÜ", "select ÄÖÜ"},
+		{"bytes above 0x7f", "lowercaseASCII", "\xc0\xaf\xff/ETC", "\xc0\xaf\xff/etc"},
+		{"lower case", "lowercaseASCII", "abc", "abc"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := applyTransforms([]string{test.transform}, test.value); got != test.want {
+				t.Errorf("%s(%q) = %q, want %q", test.transform, test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	tests := []struct {
+		transform string
+		value     string
+		want      string
+	}{
+		{"urlDecode", "%27%20or%201=1", "' or 1=1"},
+		{"urlDecode", "a+b", "a b"},
+		{"urlDecode", "%252e%252e%252f", "../"},
+		{"urlDecode", "100%", "100%"},
+		{"urlDecode", "%zz%4", "%zz%4"},
+		{"unicodeDecode", "%u002e%u002E\\u002f", "../"},
+		{"unicodeDecode", "\xc0\xae\xc0\xae\xc0\xaf", "../"},
+		{"unicodeDecode", "\xe0\x80\xaf", "/"},
+		{"unicodeDecode", "＜script＞", "<script>"},
+		{"unicodeDecode", "\xff%u12", "\xff%u12"},
+		{"htmlEntityDecode", "&lt;script&gt;&#39;&#x27;", "<script>''"},
+		{"htmlEntityDecode", "a & b", "a & b"},
+		{"removeNulls", "sel\x00ect", "select"},
+		{"compressWhitespace", "union \t\n select", "union select"},
+		{"removeComments", "admin'--comment", "admin'"},
+		{"removeComments", "1 /* a */or/**/1#x\n=1", "1 or1\n=1"},
+		{"removeComments", "/*!50000select*/", "50000select"},
+		{"replaceComments", "union/**/select", "union select"},
+		{"replaceComments", "union/*!select*/", "union select "},
+		{"replaceComments", "a--b", "a--b"},
+		{"replaceComments", "a/*b", "a "},
+	}
+	for _, test := range tests {
+		t.Run(test.transform+"("+test.value+")", func(t *testing.T) {
+			if got := applyTransforms([]string{test.transform}, test.value); got != test.want {
+				t.Errorf("%s(%q) = %q, want %q", test.transform, test.value, got, test.want)
+			}
+		})
+	}
+}
+
+// The transforms are applied in the given order, so the result depends on it
+func TestTransformsOrder(t *testing.T) {
+	value := "%26lt;SCRIPT%26gt;"
+	if got := applyTransforms([]string{"urlDecode", "htmlEntityDecode", "lowercase"}, value); got != "<script>" {
+		t.Errorf("urlDecode, htmlEntityDecode, lowercase = %q, want %q", got, "<script>")
+	}
+	if got := applyTransforms([]string{"htmlEntityDecode", "urlDecode", "lowercase"}, value); got != "&lt;script&gt;" {
+		t.Errorf("htmlEntityDecode, urlDecode, lowercase = %q, want %q", got, "&lt;script&gt;")
+	}
+}
+
+// Transformed values are cached and reused by transforms, which extend them
+func TestTransformedCache(t *testing.T) {
+	data := &Request{Fields: []Field{{Location: LocationQuery, Name: "q", Value: "%2541", RawValue: "%2541"}}}
+
+	if got := data.Transformed(0, false, ViewNormalized, []string{"urlDecode", "lowercase"}); got != "a" {
+		t.Errorf("transformed value = %q, want %q", got, "a")
+	}
+	if len(data.cache) != 2 {
+		t.Errorf("cache = %v, want the values after both transforms", data.cache)
+	}
+
+	// The cached prefix is not transformed again
+	data.cache[transformKey{field: 0, view: ViewNormalized, transforms: "urlDecode"}] = "B"
+	if got := data.Transformed(0, false, ViewNormalized, []string{"urlDecode", "lowercase", "removeNulls"}); got != "a" {
+		t.Errorf("transformed value = %q, want the cached value %q", got, "a")
+	}
+	if got := data.Transformed(0, false, ViewNormalized, []string{"urlDecode", "removeNulls"}); got != "B" {
+		t.Errorf("transformed value = %q, want the cached prefix %q", got, "B")
+	}
+	if got := data.Transformed(0, false, ViewNormalized, nil); got != "%2541" {
+		t.Errorf("value = %q, want the value without transforms", got)
+	}
+}
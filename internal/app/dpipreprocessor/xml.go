@@ -0,0 +1,177 @@
+package dpipreprocessor
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+This file parses XML and SOAP request bodies into fields. The text of every element and the value of every attribute
+becomes a field, which is named by its path, e.g. "/envelope/body/login/user" or "/envelope/body/login/@id". The
+parser does not expand any entity: references to entities, which are not predefined by XML, are kept as they are. DOCTYPE
+declarations are collected for the Detector, which checks them for external entities and entity expansion bombs.
+*/
+
+// Default limits of the XML parser
+const (
+	DefaultXMLMaxDepth = 32
+	DefaultXMLMaxSize  = 1 << 20
+)
+
+// xmlElement is an element of the XML document, which is currently parsed
+type xmlElement struct {
+	path string
+	text strings.Builder
+	raw  strings.Builder
+}
+
+// isXML() checks if the media type is an XML type like application/xml, text/xml or application/soap+xml. A body
+// starting with an XML declaration or a DOCTYPE is treated as XML independent of its media type, because many XML
+// parsers do not check the content type.
+func isXML(mediaType string, body []byte) bool {
+	if mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	body = bytes.TrimSpace(body)
+	return bytes.HasPrefix(body, []byte("<?xml")) || bytes.HasPrefix(body, []byte("<!DOCTYPE"))
+}
+
+/*
+This method adds the element texts and attribute values of an XML body as fields to the request. Malformed documents
+and documents, which exceed the configured depth or size, are reported as anomaly. The values parsed until then are
+kept.
+
+@param data: Request, to which the fields are added
+@param body: Body of the request
+*/
+func (preprocessor *Preprocessor) extractXML(data *Request, body string) {
+	truncated := data.truncated
+	if len(body) > preprocessor.xmlMaxSize {
+		data.addAnomaly(AnomalyXMLLimit, LocationXML, "", fmt.Sprintf("more than %d bytes", preprocessor.xmlMaxSize))
+		body, truncated = body[:preprocessor.xmlMaxSize], true
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	decoder.Strict = false // Unknown entities are kept instead of being rejected
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var stack []*xmlElement
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			if len(stack) > 0 && !truncated {
+				data.addAnomaly(AnomalyMalformedXML, LocationXML, "", fmt.Sprintf("element %s is not closed", stack[len(stack)-1].path))
+			}
+			return
+		}
+		if err != nil {
+			if !truncated {
+				data.addAnomaly(AnomalyMalformedXML, LocationXML, "", err.Error())
+			}
+			return
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if len(stack) >= preprocessor.xmlMaxDepth {
+				data.addAnomaly(AnomalyXMLLimit, LocationXML, "", fmt.Sprintf("more than %d nested levels", preprocessor.xmlMaxDepth))
+				return
+			}
+			path := "/" + token.Name.Local
+			if len(stack) > 0 {
+				path = stack[len(stack)-1].path + path
+			}
+			stack = append(stack, &xmlElement{path: path})
+			rawValues := rawXMLAttributes(body[start:decoder.InputOffset()])
+			for i, attr := range token.Attr {
+				name := path + "/@" + attr.Name.Local
+				if attr.Name.Space != "" {
+					name = path + "/@" + attr.Name.Space + ":" + attr.Name.Local
+				}
+				rawValue := attr.Value
+				if len(rawValues) == len(token.Attr) {
+					rawValue = rawValues[i]
+				}
+				data.add(LocationXML, name, name, rawValue, attr.Value)
+			}
+		case xml.EndElement:
+			if len(stack) == 0 || !strings.HasSuffix(stack[len(stack)-1].path, "/"+token.Name.Local) {
+				data.addAnomaly(AnomalyMalformedXML, LocationXML, "", fmt.Sprintf("unexpected end element </%s>", token.Name.Local))
+				return
+			}
+			element := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if text := strings.TrimSpace(element.text.String()); text != "" {
+				data.add(LocationXML, element.path, element.path, strings.TrimSpace(element.raw.String()), text)
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(token)
+				stack[len(stack)-1].raw.WriteString(body[start:decoder.InputOffset()])
+			}
+		case xml.Directive:
+			if bytes.HasPrefix(bytes.TrimSpace(token), []byte("DOCTYPE")) {
+				data.Doctypes = append(data.Doctypes, string(token))
+			}
+		}
+	}
+}
+
+// rawXMLAttributes() returns the values of the attributes of a start tag as they were received, i.e. without decoding
+// character and entity references, in the order of their definition. Like the decoder in non-strict mode, it accepts
+// unquoted values and attributes without value, whose value is their name.
+func rawXMLAttributes(tag string) (values []string) {
+	i := strings.IndexAny(tag, " \t\r\n")
+	if i < 0 {
+		return nil
+	}
+	for {
+		i = skipXMLSpace(tag, i)
+		start := i
+		for i < len(tag) && !isXMLSpace(tag[i]) && tag[i] != '=' && tag[i] != '>' && tag[i] != '/' {
+			i++
+		}
+		if i == start {
+			return values
+		}
+		name := tag[start:i]
+
+		i = skipXMLSpace(tag, i)
+		if i >= len(tag) || tag[i] != '=' {
+			values = append(values, name)
+			continue
+		}
+		i = skipXMLSpace(tag, i+1)
+		if i < len(tag) && (tag[i] == '"' || tag[i] == '\'') {
+			end := strings.IndexByte(tag[i+1:], tag[i])
+			if end < 0 {
+				return values
+			}
+			values = append(values, tag[i+1:i+1+end])
+			i += end + 2
+			continue
+		}
+		start = i
+		for i < len(tag) && !isXMLSpace(tag[i]) && tag[i] != '>' {
+			i++
+		}
+		values = append(values, tag[start:i])
+	}
+}
+
+func skipXMLSpace(text string, i int) int {
+	for i < len(text) && isXMLSpace(text[i]) {
+		i++
+	}
+	return i
+}
+
+func isXMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
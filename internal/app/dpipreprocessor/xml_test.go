@@ -0,0 +1,92 @@
+package dpipreprocessor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+)
+
+var xmlHeaders = map[string]string{"Content-Type": "application/xml"}
+
+func TestExtractXML(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   map[string]string
+		body      string
+		fields    [][3]string // Name, raw value and value of the expected fields
+		anomalies []string
+	}{
+		{"elements and attributes", xmlHeaders, `<login id="7"><user>alice</user><pass> x'y </pass></login>`,
+			[][3]string{{"/login/@id", "7", "7"}, {"/login/user", "alice", "alice"}, {"/login/pass", "x'y", "x'y"}}, nil},
+		{"soap namespaces", map[string]string{"Content-Type": "application/soap+xml"},
+			`<s:Envelope xmlns:s="urn:s"><s:Body><q xml:lang="de">1</q></s:Body></s:Envelope>`,
+			[][3]string{{"/envelope/@xmlns:s", "urn:s", "urn:s"}, {"/envelope/body/q/@xml:lang", "de", "de"},
+				{"/envelope/body/q", "1", "1"}}, nil},
+		{"predefined and character references", xmlHeaders, `<a>&lt;script&gt;&#x27;</a>`,
+			[][3]string{{"/a", "&lt;script&gt;&#x27;", "<script>'"}}, nil},
+		{"encoded attributes", xmlHeaders, `<a b = '&#x3c;script&#x3e;' c="&quot;x&quot;" d=1 e/>`,
+			[][3]string{{"/a/@b", "&#x3c;script&#x3e;", "<script>"}, {"/a/@c", "&quot;x&quot;", `"x"`},
+				{"/a/@d", "1", "1"}, {"/a/@e", "e", "e"}}, nil},
+		{"entities are not expanded", xmlHeaders, `<!DOCTYPE a [<!ENTITY x "y">]><a>&x;</a>`,
+			[][3]string{{"/a", "&x;", "&x;"}}, nil},
+		{"cdata", xmlHeaders, `<a><![CDATA[<script>]]></a>`, [][3]string{{"/a", "<![CDATA[<script>]]>", "<script>"}}, nil},
+		{"sniffed without media type", map[string]string{"Content-Type": "text/plain"}, `<?xml version="1.0"?><a>1</a>`,
+			[][3]string{{"/a", "1", "1"}}, nil},
+		{"unclosed element", xmlHeaders, `<a><b>1</b>`, [][3]string{{"/a/b", "1", "1"}}, []string{AnomalyMalformedXML}},
+		{"mismatched end element", xmlHeaders, `<a><b>1</a>`, nil, []string{AnomalyMalformedXML}},
+		{"too deep", xmlHeaders, strings.Repeat("<a>", 33) + strings.Repeat("</a>", 33), nil, []string{AnomalyXMLLimit}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := extract(t, config.BodyT{}, "POST", "/", test.headers, test.body)
+			var fields [][3]string
+			for _, field := range data.Fields {
+				if field.Location == LocationXML {
+					fields = append(fields, [3]string{field.Name, field.RawValue, field.Value})
+				}
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("fields = %q, want %q", fields, test.fields)
+			}
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, test.anomalies) {
+				t.Errorf("anomalies = %q, want %q", types, test.anomalies)
+			}
+		})
+	}
+}
+
+func TestExtractXMLDoctypes(t *testing.T) {
+	body := `<?xml version="1.0"?><!DOCTYPE foo [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><foo>&xxe;</foo>`
+	data := extract(t, config.BodyT{}, "POST", "/", xmlHeaders, body)
+	want := []string{`DOCTYPE foo [<!ENTITY xxe SYSTEM "file:///etc/passwd">]`}
+	if !reflect.DeepEqual(data.Doctypes, want) {
+		t.Errorf("doctypes = %q, want %q", data.Doctypes, want)
+	}
+}
+
+// A body above the size limit or cut at the inspection limit is parsed up to the limit. Only the exceeded XML limit
+// is reported, not the unclosed elements at the cut.
+func TestExtractXMLTruncated(t *testing.T) {
+	body := "<a><b>" + strings.Repeat("<c>1</c>", 100) + "</b></a>"
+	tests := []struct {
+		name      string
+		settings  config.BodyT
+		anomalies []string
+	}{
+		{"size limit", config.BodyT{XML: config.XMLT{MaxSize: 200}}, []string{AnomalyXMLLimit}},
+		{"inspection limit", config.BodyT{Inspection: config.InspectionT{MaxSize: 200}}, []string{AnomalyBodyLimit}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := extract(t, test.settings, "POST", "/", xmlHeaders, body)
+			if !hasField(data, LocationXML, "/a/b/c", "1") {
+				t.Error("values before the cut were not extracted")
+			}
+			if types := anomalyTypes(data); !reflect.DeepEqual(types, test.anomalies) {
+				t.Errorf("anomalies = %q, want %q", types, test.anomalies)
+			}
+		})
+	}
+}
@@ -11,13 +11,21 @@ import (
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"syscall"
 
 	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpidetector"
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/dpipreprocessor"
 	logger "github.com/vs-uulm/ztsfc_http_logger"
 )
 
+// DefaultRuleFiles matches the YAML rule files bundled with the service function, relative to its working directory
+// (see Dockerfile)
+const DefaultRuleFiles = "./rules/*.yml"
+
 // InitSysLoggerParams() sets the default values for a system logger
 func InitSysLoggerParams() {
 	// Set a default logging level.
@@ -44,7 +52,7 @@ func InitServFuncParams(sysLogger *logger.Logger) error {
 	var err error
 	fields := ""
 
-	if (config.Config.SF == config.ServiceFunctionT{}) {
+	if reflect.DeepEqual(config.Config.SF, config.ServiceFunctionT{}) {
 		return fmt.Errorf("init: InitServFuncParams(): the section 'sf' is empty. No service function parameters are defined")
 	}
 
@@ -84,6 +92,26 @@ func InitServFuncParams(sysLogger *logger.Logger) error {
 		return fmt.Errorf("init: InitServFuncParams(): in the section 'sf' the following required fields are missed: '%s'", strings.TrimSuffix(fields, ","))
 	}
 
+	err = initRuleFilesParams(sysLogger)
+	if err != nil {
+		return err
+	}
+
+	err = initEnforcementParams(sysLogger)
+	if err != nil {
+		return err
+	}
+
+	err = initAnomalyScoringParams(sysLogger)
+	if err != nil {
+		return err
+	}
+
+	err = initBodyParams(sysLogger)
+	if err != nil {
+		return err
+	}
+
 	// Preload SF X509KeyPair when it acts as a server and write it to config
 	config.Config.X509KeyPairShownBySFAsServer, err = loadX509KeyPair(sysLogger,
 		config.Config.SF.ServerCerts.Cert_shown_by_sf, config.Config.SF.ServerCerts.Privkey_for_cert_shown_by_sf, "service", "")
@@ -113,6 +141,174 @@ func InitServFuncParams(sysLogger *logger.Logger) error {
 	return nil
 }
 
+// initRuleFilesParams() loads the bundled YAML rules, when no rule files are configured. Configs, which were written
+// before the signatures were moved into rule files, keep the built-in patterns this way.
+func initRuleFilesParams(sysLogger *logger.Logger) error {
+	if len(config.Config.SF.RuleFiles) > 0 {
+		return nil
+	}
+
+	paths, err := filepath.Glob(DefaultRuleFiles)
+	if err != nil {
+		return fmt.Errorf("init: initRuleFilesParams(): %w", err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("init: initRuleFilesParams(): no rule files configured and no bundled rule files found in '%s'", DefaultRuleFiles)
+	}
+	config.Config.SF.RuleFiles = paths
+
+	sysLogger.Debugf("init: initRuleFilesParams(): no rule files configured, loading the bundled rule files %v - OK", paths)
+	return nil
+}
+
+// initEnforcementParams() sets the default enforcement mode and validates
+// the global mode as well as all per category overrides
+func initEnforcementParams(sysLogger *logger.Logger) error {
+	enforcement := &config.Config.SF.Enforcement
+
+	// Without an explicit mode the DPI only alerts, as it did before enforcement was configurable
+	if enforcement.Mode == "" {
+		enforcement.Mode = config.EnforcementModeDetect
+	}
+	if !isValidEnforcementMode(enforcement.Mode) {
+		return fmt.Errorf("init: initEnforcementParams(): unknown enforcement mode '%s'", enforcement.Mode)
+	}
+
+	for category, mode := range enforcement.Categories {
+		if !isValidEnforcementMode(mode) {
+			return fmt.Errorf("init: initEnforcementParams(): unknown enforcement mode '%s' for category '%s'", mode, category)
+		}
+	}
+
+	sysLogger.Debugf("init: initEnforcementParams(): enforcement mode '%s' with %d category override(s) - OK",
+		enforcement.Mode, len(enforcement.Categories))
+	return nil
+}
+
+// initAnomalyScoringParams() sets the default threshold and severity scores of the anomaly scoring.
+// The defaults correspond to the defaults of the OWASP Core Rule Set.
+func initAnomalyScoringParams(sysLogger *logger.Logger) error {
+	scoring := &config.Config.SF.AnomalyScoring
+
+	if scoring.InboundThreshold == 0 {
+		scoring.InboundThreshold = 5
+	}
+	if scoring.InboundThreshold < 0 {
+		return fmt.Errorf("init: initAnomalyScoringParams(): inbound threshold must be positive, but is %d", scoring.InboundThreshold)
+	}
+
+	// Severity names are case insensitive and normalized to lower case
+	scores := map[string]int{"critical": 5, "error": 4, "warning": 3, "notice": 2}
+	for name, score := range scoring.SeverityScores {
+		severity, err := dpidetector.ParseSeverity(name)
+		if err != nil {
+			return fmt.Errorf("init: initAnomalyScoringParams(): %w", err)
+		}
+		if score < 0 {
+			return fmt.Errorf("init: initAnomalyScoringParams(): score of severity '%s' must not be negative", name)
+		}
+		scores[severity.String()] = score
+	}
+	scoring.SeverityScores = scores
+
+	sysLogger.Debugf("init: initAnomalyScoringParams(): anomaly scoring enabled: %t, inbound threshold: %d - OK",
+		scoring.Enabled, scoring.InboundThreshold)
+	return nil
+}
+
+// initBodyParams() sets the default limits of the body parsers and validates the configured limits
+func initBodyParams(sysLogger *logger.Logger) error {
+	inspection := &config.Config.SF.Body.Inspection
+	if inspection.MaxSize == 0 {
+		inspection.MaxSize = dpipreprocessor.DefaultInspectionMaxSize
+	}
+	if inspection.ChunkSize == 0 {
+		inspection.ChunkSize = dpipreprocessor.DefaultInspectionChunkSize
+	}
+	if inspection.MemoryLimit == 0 {
+		inspection.MemoryLimit = dpipreprocessor.DefaultInspectionMemoryLimit
+	}
+	if inspection.ExceededAction == "" {
+		inspection.ExceededAction = dpipreprocessor.InspectionInspectPartial
+	}
+	if inspection.MaxSize < 0 || inspection.ChunkSize < 0 || inspection.MemoryLimit < 0 {
+		return fmt.Errorf("init: initBodyParams(): inspection limits must be positive, but are max_size: %d, chunk_size: %d, memory_limit: %d",
+			inspection.MaxSize, inspection.ChunkSize, inspection.MemoryLimit)
+	}
+	switch inspection.ExceededAction {
+	case dpipreprocessor.InspectionInspectPartial, dpipreprocessor.InspectionReject, dpipreprocessor.InspectionSkip:
+	default:
+		return fmt.Errorf("init: initBodyParams(): unknown inspection exceeded_action '%s'", inspection.ExceededAction)
+	}
+	if inspection.TempDir != "" {
+		if info, err := os.Stat(inspection.TempDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("init: initBodyParams(): temp_dir '%s' is not a directory", inspection.TempDir)
+		}
+	}
+
+	json := &config.Config.SF.Body.JSON
+
+	if json.MaxDepth == 0 {
+		json.MaxDepth = dpipreprocessor.DefaultJSONMaxDepth
+	}
+	if json.MaxElements == 0 {
+		json.MaxElements = dpipreprocessor.DefaultJSONMaxElements
+	}
+	if json.MaxDepth < 0 || json.MaxElements < 0 {
+		return fmt.Errorf("init: initBodyParams(): JSON limits must be positive, but are max_depth: %d, max_elements: %d",
+			json.MaxDepth, json.MaxElements)
+	}
+
+	xml := &config.Config.SF.Body.XML
+	if xml.MaxDepth == 0 {
+		xml.MaxDepth = dpipreprocessor.DefaultXMLMaxDepth
+	}
+	if xml.MaxSize == 0 {
+		xml.MaxSize = dpipreprocessor.DefaultXMLMaxSize
+	}
+	if xml.MaxDepth < 0 || xml.MaxSize < 0 {
+		return fmt.Errorf("init: initBodyParams(): XML limits must be positive, but are max_depth: %d, max_size: %d",
+			xml.MaxDepth, xml.MaxSize)
+	}
+
+	decompression := &config.Config.SF.Body.Decompression
+	if decompression.MaxSize == 0 {
+		decompression.MaxSize = dpipreprocessor.DefaultDecompressionMaxSize
+	}
+	if decompression.MaxRatio == 0 {
+		decompression.MaxRatio = dpipreprocessor.DefaultDecompressionMaxRatio
+	}
+	if decompression.ExceededAction == "" {
+		decompression.ExceededAction = dpidetector.ActionBlock
+	}
+	if decompression.MaxSize < 0 || decompression.MaxRatio < 0 {
+		return fmt.Errorf("init: initBodyParams(): decompression limits must be positive, but are max_size: %d, max_ratio: %d",
+			decompression.MaxSize, decompression.MaxRatio)
+	}
+	if decompression.ExceededAction != dpidetector.ActionBlock && decompression.ExceededAction != dpidetector.ActionPass {
+		return fmt.Errorf("init: initBodyParams(): unknown decompression exceeded_action '%s'", decompression.ExceededAction)
+	}
+
+	for _, upload := range config.Config.SF.Body.Uploads {
+		if !strings.HasPrefix(upload.Path, "/") {
+			return fmt.Errorf("init: initBodyParams(): path '%s' of an upload policy must start with '/'", upload.Path)
+		}
+		if upload.MaxFileSize < 0 || upload.MaxTotalSize < 0 {
+			return fmt.Errorf("init: initBodyParams(): upload limits of path '%s' must be positive", upload.Path)
+		}
+	}
+
+	sysLogger.Debugf("init: initBodyParams(): inspection max size: %d (%s), chunk size: %d, memory limit: %d - OK",
+		inspection.MaxSize, inspection.ExceededAction, inspection.ChunkSize, inspection.MemoryLimit)
+	sysLogger.Debugf("init: initBodyParams(): JSON max depth: %d, max elements: %d, XML max depth: %d, max size: %d, %d upload policies - OK",
+		json.MaxDepth, json.MaxElements, xml.MaxDepth, xml.MaxSize, len(config.Config.SF.Body.Uploads))
+	return nil
+}
+
+func isValidEnforcementMode(mode string) bool {
+	return mode == config.EnforcementModeDetect || mode == config.EnforcementModePrevent
+}
+
 // LoadX509KeyPair() unifies the loading of X509 key pairs for different components
 func loadX509KeyPair(sysLogger *logger.Logger, certfile, keyfile, componentName, certAttr string) (tls.Certificate, error) {
 	keyPair, err := tls.LoadX509KeyPair(certfile, keyfile)
@@ -144,7 +340,7 @@ func loadCACertificate(sysLogger *logger.Logger, certfile string, componentName
 }
 
 func SetupCloseHandler(logger *logger.Logger) {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -0,0 +1,58 @@
+package init
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vs-uulm/ztsfc_http_ips/internal/app/config"
+	logger "github.com/vs-uulm/ztsfc_http_logger"
+)
+
+// chdir() changes the working directory for the duration of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestInitRuleFilesParams(t *testing.T) {
+	sysLogger, err := logger.New("stdout", "error", "json", logger.Fields{"type": "system"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository, err := filepath.Abs("../../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Configured rule files are kept
+	config.Config.SF.RuleFiles = []string{"/path/to/rules.yml"}
+	if err := initRuleFilesParams(sysLogger); err != nil || len(config.Config.SF.RuleFiles) != 1 {
+		t.Errorf("error = %v, rule files = %v, want the configured rule file", err, config.Config.SF.RuleFiles)
+	}
+
+	// Without rule files the bundled YAML rule files are loaded
+	chdir(t, repository)
+	config.Config.SF.RuleFiles = nil
+	if err := initRuleFilesParams(sysLogger); err != nil {
+		t.Fatal(err)
+	}
+	bundled, _ := filepath.Glob(filepath.Join(repository, "rules", "*.yml"))
+	if len(config.Config.SF.RuleFiles) == 0 || len(config.Config.SF.RuleFiles) != len(bundled) {
+		t.Errorf("rule files = %v, want the bundled rule files %v", config.Config.SF.RuleFiles, bundled)
+	}
+
+	// Without bundled rule files the start fails
+	chdir(t, t.TempDir())
+	config.Config.SF.RuleFiles = nil
+	if err := initRuleFilesParams(sysLogger); err == nil {
+		t.Error("no error without rule files")
+	}
+}
@@ -66,6 +66,7 @@ func New(logger *logger.Logger) (*Router, error) {
 
 func (router *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
+	// Blocked requests have already been answered by the service function
 	forward := router.sf.ApplyFunction(w, req)
 	if !forward {
 		return